@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HMACKeyID is the kid used for the shared JWT_SECRET when no asymmetric keys
+// are configured.
+const HMACKeyID = "hs256"
+
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private is nil for keys that are only kept around to verify tokens
+	// issued before a rotation.
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// KeySet holds every key tokens may be verified with and the one key new
+// tokens are signed with.
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewHMACKeySet returns a key set with a single HS256 shared secret.
+func NewHMACKeySet(secret string) *KeySet {
+	key := &SigningKey{
+		ID:      HMACKeyID,
+		Method:  jwt.SigningMethodHS256,
+		private: []byte(secret),
+		public:  []byte(secret),
+	}
+	return &KeySet{active: key, keys: map[string]*SigningKey{key.ID: key}}
+}
+
+// LoadKeySet reads PEM encoded keys from the given kid to path mapping.
+// Private keys (RSA or Ed25519) can sign and verify, public keys can only
+// verify. The key named by activeID, or the first kid in sort order when
+// activeID is empty, is used for signing and must be a private key.
+func LoadKeySet(paths map[string]string, activeID string) (*KeySet, error) {
+	set := &KeySet{keys: map[string]*SigningKey{}}
+	for kid, path := range paths {
+		key, err := loadKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+	}
+
+	if activeID == "" {
+		ids := make([]string, 0, len(set.keys))
+		for kid := range set.keys {
+			ids = append(ids, kid)
+		}
+		sort.Strings(ids)
+		if len(ids) > 0 {
+			activeID = ids[0]
+		}
+	}
+
+	active, ok := set.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeID)
+	}
+	if active.private == nil {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeID)
+	}
+	set.active = active
+
+	return set, nil
+}
+
+func loadKey(kid, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key %q: %w", kid, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q is not PEM encoded", kid)
+	}
+
+	key := &SigningKey{ID: kid}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key.private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key.private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key %q: %w", kid, err)
+	}
+
+	if signer, ok := key.private.(crypto.Signer); ok {
+		key.public = signer.Public()
+	}
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("key %q must be an RSA or Ed25519 key", kid)
+	}
+
+	return key, nil
+}
+
+// AllowHMAC keeps accepting tokens signed with the shared secret without
+// signing new ones with it, so tokens issued before switching to asymmetric
+// keys stay valid until they expire.
+func (s *KeySet) AllowHMAC(secret string) error {
+	if _, ok := s.keys[HMACKeyID]; ok {
+		return fmt.Errorf("key ID %q is reserved for JWT_SECRET", HMACKeyID)
+	}
+	s.keys[HMACKeyID] = &SigningKey{
+		ID:     HMACKeyID,
+		Method: jwt.SigningMethodHS256,
+		public: []byte(secret),
+	}
+	return nil
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.private)
+}
+
+// Parse verifies a token against the key named by its kid header. Tokens
+// without a kid were issued before key rotation and are checked against the
+// active key, or the shared secret if they are HS256 and it is still
+// accepted. The token's alg must match the algorithm of the key.
+func (s *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	methods := make([]string, 0, len(s.keys))
+	for _, key := range s.keys {
+		methods = append(methods, key.Method.Alg())
+	}
+
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		key := s.active
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, ok = s.keys[kid]; !ok {
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+		} else if hmac, ok := s.keys[HMACKeyID]; ok && token.Method.Alg() == hmac.Method.Alg() {
+			key = hmac
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("signing method does not match key")
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods(methods), jwt.WithExpirationRequired())
+}
+
+// JWKS returns the public keys of the set. Shared HMAC secrets are never
+// published.
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, RSAJWK(key.ID, public))
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: "EdDSA",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
@@ -0,0 +1,209 @@
+// Command mockidp is a minimal OpenID Connect provider for local testing of
+// the single sign-on flow. It accepts any login submitted through its form
+// and must never be exposed outside a development machine.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pedersandvoll/Practice-Exam-BE/auth"
+)
+
+const keyID = "mock-idp-key"
+
+type authorization struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	email       string
+	name        string
+	groups      []string
+}
+
+type server struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!doctype html>
+<html><body>
+<h1>Mock IdP login</h1>
+<form method="post">
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}
+<p><label>Email <input name="email" value="john@email.com"></label></p>
+<p><label>Name <input name="name" value="John Doe"></label></p>
+<p><label>Groups (comma separated) <input name="groups" value="complaints-agents"></label></p>
+<button type="submit">Log in</button>
+</form>
+</body></html>`))
+
+func main() {
+	port := os.Getenv("MOCK_IDP_PORT")
+	if port == "" {
+		port = "9000"
+	}
+	issuer := os.Getenv("MOCK_IDP_ISSUER")
+	if issuer == "" {
+		issuer = "http://localhost:" + port
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Could not generate signing key: %v", err)
+	}
+
+	s := &server{issuer: issuer, key: key, codes: map[string]authorization{}}
+
+	http.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	http.HandleFunc("/authorize", s.authorize)
+	http.HandleFunc("/token", s.token)
+	http.HandleFunc("/jwks", s.jwks)
+
+	log.Printf("Mock IdP listening on %s", issuer)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
+
+func (s *server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+			http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+			return
+		}
+		loginForm.Execute(w, map[string]interface{}{"Params": r.URL.Query()})
+		return
+	}
+
+	code := randomString()
+	var groups []string
+	for _, g := range strings.Split(r.Form.Get("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		clientID:    r.Form.Get("client_id"),
+		redirectURI: r.Form.Get("redirect_uri"),
+		nonce:       r.Form.Get("nonce"),
+		challenge:   r.Form.Get("code_challenge"),
+		email:       r.Form.Get("email"),
+		name:        r.Form.Get("name"),
+		groups:      groups,
+	}
+	s.mu.Unlock()
+
+	redirect, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	redirect.RawQuery = q.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	s.mu.Lock()
+	authz, ok := s.codes[r.Form.Get("code")]
+	delete(s.codes, r.Form.Get("code"))
+	s.mu.Unlock()
+
+	if !ok || authz.clientID != r.Form.Get("client_id") || authz.redirectURI != r.Form.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != authz.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.issuer,
+		"sub":            "mock|" + authz.email,
+		"aud":            authz.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          authz.nonce,
+		"email":          authz.email,
+		"email_verified": true,
+		"name":           authz.name,
+		"groups":         authz.groups,
+	})
+	idToken.Header["kid"] = keyID
+
+	signed, err := idToken.SignedString(s.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (s *server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, auth.JWKS{Keys: []auth.JWK{auth.RSAJWK(keyID, &s.key.PublicKey)}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -21,25 +23,141 @@ type Database struct {
 }
 
 type Config struct {
-	Host      string
-	Port      string
-	User      string
-	Password  string
-	DBName    string
-	SSLMode   string
-	JWTSecret string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	DBName   string
+	SSLMode  string
+	AppEnv   string
+
+	JWTSecret    string
+	JWTKeys      map[string]string
+	JWTActiveKid string
+
+	AppURL string
+
+	SMTPHost           string
+	SMTPPort           string
+	SMTPUsername       string
+	SMTPPassword       string
+	SMTPFrom           string
+	EmailDigestMinutes int
+
+	// ComplaintRetentionDays is how long deleted complaints can be restored
+	// before they are purged. 0 keeps them forever.
+	ComplaintRetentionDays int
+
+	// New complaints are compared with the same customer's complaints from
+	// the last DuplicateWindowDays; those at least DuplicateThreshold similar
+	// (trigram similarity, 0 to 1) are reported as possible duplicates.
+	DuplicateWindowDays int
+	DuplicateThreshold  float64
+
+	LocalLogin  bool
+	AdminEmails []string
+
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCGroupsClaim  string
+	OIDCGroupRoles   map[string]string
+	OIDCDefaultRole  string
+	OIDCLoginURL     string
 }
 
+const defaultJWTSecret = "your-default-secret-key"
+
 func NewConfig() *Config {
 	return &Config{
-		Host:      getEnv("DB_HOST", "localhost"),
-		Port:      getEnv("DB_PORT", "5432"),
-		User:      getEnv("DB_USER", "postgres"),
-		Password:  getEnv("DB_PASSWORD", "password"),
-		DBName:    getEnv("DB_NAME", "dbname"),
-		SSLMode:   getEnv("DB_SSLMODE", "disable"),
-		JWTSecret: getEnv("JWT_SECRET", "your-default-secret-key"),
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnv("DB_PORT", "5432"),
+		User:     getEnv("DB_USER", "postgres"),
+		Password: getEnv("DB_PASSWORD", "password"),
+		DBName:   getEnv("DB_NAME", "dbname"),
+		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+		AppEnv:   getEnv("APP_ENV", "development"),
+
+		JWTSecret:    getEnv("JWT_SECRET", defaultJWTSecret),
+		JWTKeys:      parseMapping(getEnv("JWT_KEYS", "")),
+		JWTActiveKid: getEnv("JWT_ACTIVE_KID", ""),
+
+		AppURL: getEnv("APP_URL", "http://localhost:3000"),
+
+		SMTPHost:           getEnv("SMTP_HOST", ""),
+		SMTPPort:           getEnv("SMTP_PORT", "1025"),
+		SMTPUsername:       getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:       getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:           getEnv("SMTP_FROM", "complaints@localhost"),
+		EmailDigestMinutes: getEnvInt("EMAIL_DIGEST_MINUTES", 60),
+
+		ComplaintRetentionDays: getEnvInt("COMPLAINT_RETENTION_DAYS", 30),
+
+		DuplicateWindowDays: getEnvInt("DUPLICATE_WINDOW_DAYS", 30),
+		DuplicateThreshold:  getEnvFloat("DUPLICATE_THRESHOLD", 0.4),
+
+		LocalLogin:  getEnv("LOCAL_LOGIN_ENABLED", "true") == "true",
+		AdminEmails: strings.FieldsFunc(getEnv("ADMIN_EMAILS", ""), func(r rune) bool { return r == ',' }),
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:3000/auth/oidc/callback"),
+		OIDCScopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid profile email groups")),
+		OIDCGroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCGroupRoles:   parseMapping(getEnv("OIDC_GROUP_ROLES", "")),
+		OIDCDefaultRole:  getEnv("OIDC_DEFAULT_ROLE", "agent"),
+		OIDCLoginURL:     getEnv("OIDC_POST_LOGIN_URL", ""),
+	}
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// parseMapping parses a "key=value,key=value" list as used by OIDC_GROUP_ROLES.
+func parseMapping(value string) map[string]string {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && key != "" {
+			mapping[key] = val
+		}
+	}
+	return mapping
+}
+
+func (c *Config) IsProduction() bool {
+	return c.AppEnv == "production"
+}
+
+// HasJWTSecret reports whether JWT_SECRET was changed from its placeholder
+// values.
+func (c *Config) HasJWTSecret() bool {
+	return c.JWTSecret != defaultJWTSecret && c.JWTSecret != "your-long-random-string-here"
+}
+
+// Validate rejects configurations that are unsafe to run in production.
+func (c *Config) Validate() error {
+	if !c.IsProduction() || len(c.JWTKeys) > 0 {
+		return nil
+	}
+	if !c.HasJWTSecret() {
+		return fmt.Errorf("JWT_SECRET must be changed from its default value when APP_ENV=production")
 	}
+	return nil
 }
 
 func getEnv(key, defaultValue string) string {
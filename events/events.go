@@ -0,0 +1,117 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ComplaintCreated  = "complaint.created"
+	ComplaintUpdated  = "complaint.updated"
+	ComplaintAssigned = "complaint.assigned"
+	ComplaintDeleted  = "complaint.deleted"
+	ComplaintRestored = "complaint.restored"
+	ComplaintMerged   = "complaint.merged"
+	ComplaintUnmerged = "complaint.unmerged"
+	CommentCreated    = "comment.created"
+	CommentEdited     = "comment.edited"
+	CommentDeleted    = "comment.deleted"
+	// CommentMentioned is recorded when editing a comment mentions users it
+	// didn't mention before. Mentions holds only those users.
+	CommentMentioned = "comment.mentioned"
+)
+
+type Event struct {
+	ID          uint64 `json:"id"`
+	Type        string `json:"type"`
+	ComplaintID uint   `json:"complaintId"`
+	CustomerID  uint   `json:"customerId"`
+	CategoryID  uint   `json:"categoryId"`
+	AssigneeID  *uint  `json:"assigneeId"`
+	CommentID   uint   `json:"commentId,omitempty"`
+	// Visibility is the visibility of the comment for comment events.
+	Visibility string    `json:"visibility,omitempty"`
+	Mentions   []uint    `json:"mentions,omitempty"`
+	ActorID    uint      `json:"actorId"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Broker fans complaint events out to streaming clients and keeps a short
+// history so clients can resume from the last event ID they saw. It is fed
+// by Listen, so event IDs are outbox row IDs.
+type Broker struct {
+	mu          sync.Mutex
+	history     []Event
+	historySize int
+	subscribers map[chan Event]struct{}
+}
+
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		historySize: historySize,
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Publish delivers the event to all subscribers. Slow subscribers that have
+// a full buffer miss the event and are expected to resume from their last ID.
+func (b *Broker) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The outbox delivers at least once; don't show clients an event twice.
+	for _, seen := range b.history {
+		if seen.ID == event.ID {
+			return
+		}
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. Events published after lastID are
+// returned for replay. Replay is by position rather than by comparing IDs
+// because outbox IDs are not guaranteed to be dispatched in order. complete
+// is false when lastID is no longer in the history (it is too old or from
+// before a restart); the whole history is replayed and the client should
+// reload its state.
+func (b *Broker) Subscribe(lastID uint64) (replay []Event, complete bool, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = true
+	if lastID > 0 {
+		complete = false
+		for i, event := range b.history {
+			if event.ID == lastID {
+				complete = true
+				replay = append(replay, b.history[i+1:]...)
+				break
+			}
+		}
+		if !complete {
+			replay = append(replay, b.history...)
+		}
+	}
+
+	sub := make(chan Event, 64)
+	b.subscribers[sub] = struct{}{}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, sub)
+	}
+
+	return replay, complete, sub, cancel
+}
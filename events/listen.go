@@ -0,0 +1,138 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+const (
+	listenRetry = 5 * time.Second
+	// listenOverlap is how far before the newest event seen catching up
+	// starts, for events whose transaction committed after it did.
+	listenOverlap = time.Minute
+)
+
+// Listen feeds the broker every outbox event as soon as it is committed, by
+// listening on OutboxChannel. Every instance runs its own listener, so
+// streaming clients get all events whichever instance they are connected to.
+// After losing its connection it reconnects and catches up on the events
+// created since shortly before the newest one it saw; clients that still
+// miss an event are sent a reset when they resume.
+func (b *Broker) Listen(ctx context.Context, db *gorm.DB) {
+	var newest time.Time
+	for {
+		err := b.listen(ctx, db, &newest)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("Outbox listen error:", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetry):
+		}
+	}
+}
+
+func (b *Broker) listen(ctx context.Context, db *gorm.DB, newest *time.Time) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("listening needs the pgx driver")
+		}
+		pgConn := stdConn.Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+OutboxChannel); err != nil {
+			return err
+		}
+		// The connection goes back to the pool, so stop listening on it.
+		defer pgConn.Exec(context.Background(), "UNLISTEN "+OutboxChannel)
+
+		if !newest.IsZero() {
+			if err := b.catchUp(ctx, db, newest); err != nil {
+				return err
+			}
+		}
+
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			id, err := strconv.ParseUint(notification.Payload, 10, 64)
+			if err != nil {
+				continue
+			}
+			var cursor uint
+			if _, err := b.publishRows(ctx, db.Where("id = ?", id), &cursor, newest); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// catchUp publishes the events created since shortly before newest, a page
+// at a time until none are left. Publish drops the ones already seen. An
+// event can commit after one with a higher ID, so this goes by creation time
+// rather than by ID.
+func (b *Broker) catchUp(ctx context.Context, db *gorm.DB, newest *time.Time) error {
+	from := newest.Add(-listenOverlap)
+	var cursor uint
+	total := 0
+	for {
+		n, err := b.publishRows(ctx, db.Where("created_at >= ? AND id > ?", from, cursor), &cursor, newest)
+		if err != nil {
+			return err
+		}
+		total += n
+		if n < b.historySize {
+			break
+		}
+	}
+	if total > b.historySize {
+		log.Printf("Outbox listener caught up on %d events, more than the %d streaming clients can resume from", total, b.historySize)
+	}
+	return nil
+}
+
+// publishRows publishes up to historySize outbox events matched by query in
+// ID order and returns how many it read. cursor is moved to the highest ID
+// read and newest to the latest creation time.
+func (b *Broker) publishRows(ctx context.Context, query *gorm.DB, cursor *uint, newest *time.Time) (int, error) {
+	var rows []tables.OutboxEvents
+	if err := query.WithContext(ctx).Order("id").Limit(b.historySize).Find(&rows).Error; err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		if row.ID > *cursor {
+			*cursor = row.ID
+		}
+		if row.CreatedAt.After(*newest) {
+			*newest = row.CreatedAt
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(row.Payload), &event); err != nil {
+			continue
+		}
+		event.ID = uint64(row.ID)
+		b.Publish(event)
+	}
+	return len(rows), nil
+}
@@ -0,0 +1,241 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	outboxBatchSize   = 50
+	outboxMaxAttempts = 10
+	outboxRetention   = 7 * 24 * time.Hour
+	// outboxLease is how long a claimed batch is reserved for the dispatcher
+	// that claimed it. If that dispatcher dies, another one picks the
+	// events up once the lease runs out.
+	outboxLease = 5 * time.Minute
+)
+
+// OutboxChannel is the Postgres notification channel the ID of every new
+// outbox event is sent on once its transaction commits.
+const OutboxChannel = "outbox_events"
+
+// Subscriber handles events from the outbox. Delivery is at-least-once, so
+// Handle must be safe to call more than once for the same event ID.
+type Subscriber interface {
+	Handle(ctx context.Context, event Event) error
+}
+
+// SubscriberFunc adapts a function to the Subscriber interface.
+type SubscriberFunc func(ctx context.Context, event Event) error
+
+func (f SubscriberFunc) Handle(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Record writes an event to the outbox. Call it with the transaction that
+// makes the change the event describes, so the event is stored if and only
+// if the change is committed. Listeners on OutboxChannel are notified on
+// commit.
+func Record(tx *gorm.DB, event Event) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	row := tables.OutboxEvents{
+		Type:          event.Type,
+		ComplaintID:   event.ComplaintID,
+		Payload:       string(payload),
+		NextAttemptAt: event.CreatedAt,
+		CreatedAt:     event.CreatedAt,
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return err
+	}
+	return tx.Exec("SELECT pg_notify(?, ?)", OutboxChannel, strconv.FormatUint(uint64(row.ID), 10)).Error
+}
+
+type namedSubscriber struct {
+	name       string
+	subscriber Subscriber
+}
+
+// Dispatcher polls the outbox and delivers pending events to subscribers.
+// Several instances can run against the same database; rows are claimed
+// with SKIP LOCKED and leased for outboxLease, so each event is processed by
+// one dispatcher at a time. Subscribers run after the claim has committed,
+// so slow ones such as SMTP don't hold row locks. Each instance's live
+// update stream is fed by Broker.Listen instead, since every instance needs
+// every event.
+type Dispatcher struct {
+	db           *gorm.DB
+	pollInterval time.Duration
+	subscribers  []namedSubscriber
+}
+
+func NewDispatcher(db *gorm.DB, pollInterval time.Duration) *Dispatcher {
+	return &Dispatcher{db: db, pollInterval: pollInterval}
+}
+
+// Subscribe registers a subscriber under a stable name. The name is stored
+// with each successful delivery, so renaming a subscriber causes pending
+// events to be delivered to it again.
+func (d *Dispatcher) Subscribe(name string, subscriber Subscriber) {
+	d.subscribers = append(d.subscribers, namedSubscriber{name: name, subscriber: subscriber})
+}
+
+// Run dispatches events until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	poll := time.NewTicker(d.pollInterval)
+	defer poll.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cleanup.C:
+			d.cleanup()
+		case <-poll.C:
+			for {
+				n, err := d.dispatchBatch(ctx)
+				if err != nil {
+					log.Println("Outbox dispatch error:", err)
+				}
+				if err != nil || n < outboxBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// claim reserves a batch of due events by pushing their next attempt past
+// the lease.
+func (d *Dispatcher) claim(ctx context.Context) ([]tables.OutboxEvents, error) {
+	var rows []tables.OutboxEvents
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("processed_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now).
+			Order("id").
+			Limit(outboxBatchSize).
+			Find(&rows)
+		if result.Error != nil || len(rows) == 0 {
+			return result.Error
+		}
+
+		ids := make([]uint, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		return tx.Model(&tables.OutboxEvents{}).Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(outboxLease)).Error
+	})
+	return rows, err
+}
+
+func (d *Dispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	rows, err := d.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		if err := d.dispatch(ctx, row); err != nil {
+			return len(rows), err
+		}
+	}
+	return len(rows), nil
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, row tables.OutboxEvents) error {
+	db := d.db.WithContext(ctx)
+
+	var event Event
+	if err := json.Unmarshal([]byte(row.Payload), &event); err != nil {
+		now := time.Now()
+		return db.Model(&row).Updates(map[string]interface{}{
+			"failed_at":  &now,
+			"last_error": fmt.Sprintf("invalid payload: %v", err),
+		}).Error
+	}
+	event.ID = uint64(row.ID)
+
+	var delivered []string
+	if err := db.Model(&tables.OutboxDeliveries{}).
+		Where("event_id = ?", row.ID).
+		Pluck("subscriber", &delivered).Error; err != nil {
+		return err
+	}
+	done := map[string]bool{}
+	for _, name := range delivered {
+		done[name] = true
+	}
+
+	var failure error
+	for _, sub := range d.subscribers {
+		if done[sub.name] {
+			continue
+		}
+		if err := sub.subscriber.Handle(ctx, event); err != nil {
+			failure = fmt.Errorf("%s: %w", sub.name, err)
+			continue
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&tables.OutboxDeliveries{EventID: row.ID, Subscriber: sub.name}).Error; err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	if failure == nil {
+		return db.Model(&row).Update("processed_at", &now).Error
+	}
+
+	attempts := row.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      failure.Error(),
+		"next_attempt_at": now.Add(backoff(attempts)),
+	}
+	if attempts >= outboxMaxAttempts {
+		updates["failed_at"] = &now
+		log.Printf("Outbox event %d failed permanently: %v", row.ID, failure)
+	}
+	return db.Model(&row).Updates(updates).Error
+}
+
+// backoff doubles the retry delay per attempt, capped at one hour.
+func backoff(attempts int) time.Duration {
+	delay := time.Second << attempts
+	if delay > time.Hour || delay <= 0 {
+		return time.Hour
+	}
+	return delay
+}
+
+func (d *Dispatcher) cleanup() {
+	cutoff := time.Now().Add(-outboxRetention)
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		processed := tx.Model(&tables.OutboxEvents{}).Select("id").Where("processed_at < ?", cutoff)
+		if err := tx.Where("event_id IN (?)", processed).Delete(&tables.OutboxDeliveries{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("processed_at < ?", cutoff).Delete(&tables.OutboxEvents{}).Error
+	})
+	if err != nil {
+		log.Println("Outbox cleanup error:", err)
+	}
+}
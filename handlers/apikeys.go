@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"github.com/pedersandvoll/Practice-Exam-BE/utils"
+)
+
+type APIKeyBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (h *Handlers) CreateAPIKey(c *fiber.Ctx) error {
+	if c.Locals("apikey") != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "API keys cannot be used to manage API keys",
+		})
+	}
+
+	var body APIKeyBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if body.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "API key name is required",
+		})
+	}
+
+	if len(body.Scopes) == 0 {
+		body.Scopes = []string{tables.ScopeRead, tables.ScopeWrite}
+	}
+	for _, scope := range body.Scopes {
+		if scope != tables.ScopeRead && scope != tables.ScopeWrite && scope != tables.ScopeInternal {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid scope. Must be read, write or internal.",
+			})
+		}
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	key, prefix, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate API key",
+		})
+	}
+
+	apiKey := tables.APIKeys{
+		Name:    body.Name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  strings.Join(body.Scopes, ","),
+		UserID:  userID,
+	}
+	result := h.db.DB.Create(&apiKey)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create API key",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	// The plaintext key is only ever returned here; we store just the hash.
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "API key created successfully",
+		"apikeyid": apiKey.ID,
+		"key":      key,
+	})
+}
+
+func (h *Handlers) GetAPIKeys(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	var apiKeys []tables.APIKeys
+	result := h.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&apiKeys)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get API keys",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(apiKeys)
+}
+
+func (h *Handlers) RevokeAPIKey(c *fiber.Ctx) error {
+	apiKeyID := c.Params("id")
+	if apiKeyID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID is required in the URL",
+		})
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	result := h.db.Model(&tables.APIKeys{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", apiKeyID, userID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke API key",
+			"msg":   result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "API key not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "API key revoked successfully",
+		"apikeyid": apiKeyID,
+	})
+}
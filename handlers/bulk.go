@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"github.com/pedersandvoll/Practice-Exam-BE/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxBulkComplaints = 500
+
+// bulkFields are the fields a bulk update may change. Descriptions and dates
+// are specific to each complaint and can only be edited one at a time.
+var bulkFields = map[string]bool{
+	"status":   true,
+	"priority": true,
+	"category": true,
+	"assignee": true,
+}
+
+type BulkUpdateBody struct {
+	IDs     []uint                     `json:"ids"`
+	Filter  *ComplaintFilter           `json:"filter"`
+	Changes map[string]json.RawMessage `json:"changes"`
+	// Atomic applies all changes in one transaction: either every complaint
+	// is updated or none is. Otherwise each complaint is updated on its own
+	// and the result for each one is reported.
+	Atomic bool `json:"atomic"`
+}
+
+const (
+	BulkUpdated   = "updated"
+	BulkUnchanged = "unchanged"
+	BulkNotFound  = "not_found"
+	BulkFailed    = "failed"
+)
+
+type BulkResult struct {
+	ComplaintID uint   `json:"complaintid"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+var errBulkNotFound = errors.New("complaints not found")
+
+// bulkTargets returns the IDs of the complaints a bulk update applies to.
+func (h *Handlers) bulkTargets(body BulkUpdateBody, userID uint) ([]uint, error) {
+	if body.Filter == nil {
+		seen := map[uint]bool{}
+		ids := make([]uint, 0, len(body.IDs))
+		for _, id := range body.IDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+
+	var ids []uint
+	err := body.Filter.apply(h.db.Model(&tables.Complaints{}), userID).
+		Order("complaints.id").
+		Limit(maxBulkComplaints+1).
+		Pluck("complaints.id", &ids).Error
+	return ids, err
+}
+
+// bulkUpdateOne applies a patch to one complaint, recording its history and
+// events. It reports whether anything changed. Moving a complaint to a
+// category whose required custom fields it doesn't have fails with a
+// customFieldError.
+func bulkUpdateOne(tx *gorm.DB, complaint tables.Complaints, patch complaintPatch, userID uint, batchID string) (bool, error) {
+	before := complaint
+	if err := patch.applyCustomFields(tx, &complaint); err != nil {
+		return false, err
+	}
+	assigned := patch.applyTo(&complaint)
+	changes := complaintChanges(before, complaint)
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	complaint.Version = before.Version + 1
+	if err := saveVersioned(tx, &complaint, before.Version); err != nil {
+		return false, err
+	}
+	if err := recordHistory(tx, complaint.ID, changes, userID, batchID); err != nil {
+		return false, err
+	}
+	if err := recordComplaintEvent(tx, events.ComplaintUpdated, complaint, userID, 0); err != nil {
+		return false, err
+	}
+	if assigned {
+		if err := follow(tx, complaint.ID, complaint.AssigneeID); err != nil {
+			return false, err
+		}
+		if err := recordComplaintEvent(tx, events.ComplaintAssigned, complaint, userID, 0); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func bulkStatus(changed bool) string {
+	if changed {
+		return BulkUpdated
+	}
+	return BulkUnchanged
+}
+
+// BulkUpdateComplaints changes status, priority, category and/or assignee on
+// many complaints at once, chosen either by ID or with the same filters as
+// the complaint list. Every change is recorded in the complaint history with
+// a shared batch ID.
+func (h *Handlers) BulkUpdateComplaints(c *fiber.Ctx) error {
+	var body BulkUpdateBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if (len(body.IDs) == 0) == (body.Filter == nil) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Either ids or filter is required, but not both",
+		})
+	}
+	if body.Filter != nil && body.Filter.isEmpty() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Filter must have at least one condition",
+		})
+	}
+	if len(body.Changes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Changes are required",
+		})
+	}
+	for field := range body.Changes {
+		if !bulkFields[field] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Field %q can't be changed in bulk", field),
+			})
+		}
+	}
+	patch, err := h.parseComplaintPatch(body.Changes)
+	if err != nil {
+		return patchFailed(c, err)
+	}
+
+	userID, _ := currentUserID(c)
+	ids, err := h.bulkTargets(body, userID)
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to find complaints",
+			"msg":   err.Error(),
+		})
+	}
+	if len(ids) > maxBulkComplaints {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("A bulk update can change at most %d complaints", maxBulkComplaints),
+		})
+	}
+
+	batchID, _, err := utils.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start bulk update",
+		})
+	}
+
+	results := make([]BulkResult, 0, len(ids))
+	updated := 0
+
+	var fieldErr *customFieldError
+	if body.Atomic {
+		err = h.db.Transaction(func(tx *gorm.DB) error {
+			var complaints []tables.Complaints
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id IN ?", ids).Find(&complaints).Error; err != nil {
+				return err
+			}
+			byID := make(map[uint]tables.Complaints, len(complaints))
+			for _, complaint := range complaints {
+				byID[complaint.ID] = complaint
+			}
+			for _, id := range ids {
+				if _, ok := byID[id]; !ok {
+					results = append(results, BulkResult{ComplaintID: id, Status: BulkNotFound})
+				}
+			}
+			if len(results) > 0 {
+				return errBulkNotFound
+			}
+
+			for _, id := range ids {
+				changed, err := bulkUpdateOne(tx, byID[id], patch, userID, batchID)
+				if errors.As(err, &fieldErr) {
+					// Nothing is kept, so only the complaint that failed
+					// is reported.
+					results = []BulkResult{{ComplaintID: id, Status: BulkFailed, Error: err.Error()}}
+				}
+				if err != nil {
+					return err
+				}
+				if changed {
+					updated++
+				}
+				results = append(results, BulkResult{ComplaintID: id, Status: bulkStatus(changed)})
+			}
+			return nil
+		})
+
+		if errors.Is(err, errBulkNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error":   "Some complaints were not found, nothing was changed",
+				"results": results,
+			})
+		}
+		if errors.As(err, &fieldErr) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Some complaints can't be changed, nothing was changed",
+				"results": results,
+			})
+		}
+		if err != nil {
+			fmt.Println("Database error:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update complaints, nothing was changed",
+				"msg":   err.Error(),
+			})
+		}
+	} else {
+		for _, id := range ids {
+			var changed bool
+			err := h.db.Transaction(func(tx *gorm.DB) error {
+				var complaint tables.Complaints
+				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&complaint, id).Error; err != nil {
+					return err
+				}
+				var err error
+				changed, err = bulkUpdateOne(tx, complaint, patch, userID, batchID)
+				return err
+			})
+
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				results = append(results, BulkResult{ComplaintID: id, Status: BulkNotFound})
+			case errors.As(err, &fieldErr):
+				results = append(results, BulkResult{ComplaintID: id, Status: BulkFailed, Error: err.Error()})
+			case err != nil:
+				fmt.Println("Database error:", err)
+				results = append(results, BulkResult{ComplaintID: id, Status: BulkFailed, Error: err.Error()})
+			default:
+				if changed {
+					updated++
+				}
+				results = append(results, BulkResult{ComplaintID: id, Status: bulkStatus(changed)})
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Bulk update finished",
+		"batchid": batchID,
+		"updated": updated,
+		"results": results,
+	})
+}
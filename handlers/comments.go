@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+func isAdmin(c *fiber.Ctx) bool {
+	return c.Locals("role") == tables.RoleAdmin
+}
+
+// canSeeInternal reports whether the caller may see internal notes. Customer
+// users and API keys without the internal scope only see public comments.
+func canSeeInternal(c *fiber.Ctx) bool {
+	if c.Locals("role") == tables.RoleCustomer {
+		return false
+	}
+	if key, ok := c.Locals("apikey").(tables.APIKeys); ok {
+		return key.HasScope(tables.ScopeInternal)
+	}
+	return true
+}
+
+// visibleComments limits a comments query to what the caller may see.
+func visibleComments(c *fiber.Ctx, db *gorm.DB) *gorm.DB {
+	if canSeeInternal(c) {
+		return db
+	}
+	return db.Where("comments.visibility = ?", tables.VisibilityPublic)
+}
+
+// loadOwnComment loads the comment in the :id route parameter and checks
+// that the caller wrote it or is an admin. When it returns a nil comment the
+// error response has already been written and should be returned as-is.
+func (h *Handlers) loadOwnComment(c *fiber.Ctx) (*tables.Comments, uint, error) {
+	commentID := c.Params("id")
+	if commentID == "" {
+		return nil, 0, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID is required in the URL",
+		})
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, 0, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	var comment tables.Comments
+	result := h.db.First(&comment, commentID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, 0, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Comment not found",
+			})
+		}
+		return nil, 0, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load comment",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	if comment.CreatedByID != userID && !isAdmin(c) {
+		return nil, 0, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author or an admin can change this comment",
+		})
+	}
+
+	if comment.DeletedAt != nil {
+		return nil, 0, c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "Comment has been deleted",
+		})
+	}
+
+	return &comment, userID, nil
+}
+
+func (h *Handlers) EditComment(c *fiber.Ctx) error {
+	var body CommentBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if body.Comment == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Comment is required",
+		})
+	}
+
+	if body.Visibility != "" && (!validVisibility(body.Visibility) || !canSeeInternal(c)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid visibility. Must be internal or public.",
+		})
+	}
+
+	comment, userID, err := h.loadOwnComment(c)
+	if comment == nil {
+		return err
+	}
+	if body.Visibility == "" {
+		body.Visibility = comment.Visibility
+	}
+
+	mentions, err := h.resolveMentions(body.Comment, body.Visibility)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to resolve mentions",
+			"msg":   err.Error(),
+		})
+	}
+
+	var complaint tables.Complaints
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&complaint, comment.ComplaintID).Error; err != nil {
+			return err
+		}
+
+		revision := tables.CommentRevisions{
+			CommentID:  comment.ID,
+			Comment:    comment.Comment,
+			EditedByID: userID,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(comment).Updates(map[string]interface{}{
+			"comment":    body.Comment,
+			"visibility": body.Visibility,
+			"edited_at":  &now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var mentioned []uint
+		if err := tx.Model(&tables.CommentMentions{}).Where("comment_id = ?", comment.ID).Pluck("user_id", &mentioned).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("comment_id = ?", comment.ID).Delete(&tables.CommentMentions{}).Error; err != nil {
+			return err
+		}
+		if err := saveMentions(tx, comment.ID, mentions); err != nil {
+			return err
+		}
+		comment.Mentions = mentions
+
+		if err := recordCommentEvent(tx, events.CommentEdited, complaint, *comment, userID); err != nil {
+			return err
+		}
+
+		// Only users the edit mentions for the first time are notified.
+		wasMentioned := make(map[uint]bool, len(mentioned))
+		for _, id := range mentioned {
+			wasMentioned[id] = true
+		}
+		added := *comment
+		added.Mentions = nil
+		for _, user := range mentions {
+			if !wasMentioned[user.ID] {
+				added.Mentions = append(added.Mentions, user)
+			}
+		}
+		if len(added.Mentions) == 0 {
+			return nil
+		}
+		return recordCommentEvent(tx, events.CommentMentioned, complaint, added, userID)
+	})
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update comment",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":   "Comment updated successfully",
+		"commentid": comment.ID,
+		"mentions":  newMentionResponses(mentions),
+	})
+}
+
+// DeleteComment soft-deletes a comment. The row stays so replies and the
+// order of the thread are unaffected, but its text is moved to the revision
+// history and its mentions are removed.
+func (h *Handlers) DeleteComment(c *fiber.Ctx) error {
+	comment, userID, err := h.loadOwnComment(c)
+	if comment == nil {
+		return err
+	}
+
+	var complaint tables.Complaints
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&complaint, comment.ComplaintID).Error; err != nil {
+			return err
+		}
+
+		revision := tables.CommentRevisions{
+			CommentID:  comment.ID,
+			Comment:    comment.Comment,
+			EditedByID: userID,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(comment).Updates(map[string]interface{}{
+			"comment":       "",
+			"deleted_at":    time.Now(),
+			"deleted_by_id": userID,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("comment_id = ?", comment.ID).Delete(&tables.CommentMentions{}).Error; err != nil {
+			return err
+		}
+
+		return recordCommentEvent(tx, events.CommentDeleted, complaint, *comment, userID)
+	})
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete comment",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":   "Comment deleted successfully",
+		"commentid": comment.ID,
+	})
+}
+
+// GetComplaintComments returns one page of the comments on a complaint that
+// the caller can see. Deleted comments are included as placeholders so that
+// page boundaries don't shift when a comment is removed.
+func (h *Handlers) GetComplaintComments(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	pageSize := c.QueryInt("pageSize", 20)
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+	sortOrder := c.Query("sortOrder", "desc")
+	if sortOrder != "asc" && sortOrder != "desc" {
+		sortOrder = "desc"
+	}
+
+	var complaint tables.Complaints
+	if err := h.db.Select("id").First(&complaint, complaintID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get complaint",
+			"msg":   err.Error(),
+		})
+	}
+
+	query := func() *gorm.DB {
+		return visibleComments(c, h.db.Model(&tables.Comments{})).Where("complaint_id = ?", complaintID)
+	}
+
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count comments",
+			"msg":   err.Error(),
+		})
+	}
+
+	comments := []tables.Comments{}
+	result := query().
+		Preload("CreatedBy", withDeleted).
+		Preload("Mentions").
+		Order("comments.created_at " + sortOrder).
+		Order("comments.id " + sortOrder).
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&comments)
+
+	if result.Error != nil {
+		fmt.Println("Database error:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get comments",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"comments": comments,
+		"page":     page,
+		"pageSize": pageSize,
+		"total":    total,
+	})
+}
+
+// GetCommentRevisions lists the previous versions of a comment to its author
+// and admins. Deleting a comment moves its text into the revisions, so for a
+// deleted comment only admins see the text.
+func (h *Handlers) GetCommentRevisions(c *fiber.Ctx) error {
+	commentID := c.Params("id")
+	if commentID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID is required in the URL",
+		})
+	}
+
+	var comment tables.Comments
+	result := visibleComments(c, h.db.DB).First(&comment, commentID)
+	if result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Comment not found",
+		})
+	}
+
+	userID, _ := currentUserID(c)
+	if comment.CreatedByID != userID && !isAdmin(c) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author or an admin can see the revisions of this comment",
+		})
+	}
+
+	var revisions []tables.CommentRevisions
+	result = h.db.Preload("EditedBy", withDeleted).
+		Where("comment_id = ?", commentID).
+		Order("created_at DESC").
+		Find(&revisions)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get comment revisions",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	if comment.DeletedAt != nil && !isAdmin(c) {
+		for i := range revisions {
+			revisions[i].Comment = ""
+		}
+	}
+
+	return c.JSON(revisions)
+}
+
+// buildCommentTree nests replies under their parent comment. Top-level
+// comments keep their order and replies are sorted oldest first. A reply
+// whose parent isn't in the list (because the caller can't see it) is shown
+// at the top level.
+func buildCommentTree(comments []tables.Comments) []tables.Comments {
+	byID := make(map[uint]int, len(comments))
+	for i, comment := range comments {
+		byID[comment.ID] = i
+	}
+
+	children := map[uint][]tables.Comments{}
+	var roots []tables.Comments
+	for _, comment := range comments {
+		if comment.ParentID != nil {
+			if _, ok := byID[*comment.ParentID]; ok {
+				children[*comment.ParentID] = append(children[*comment.ParentID], comment)
+				continue
+			}
+		}
+		roots = append(roots, comment)
+	}
+
+	var attach func(comment tables.Comments) tables.Comments
+	attach = func(comment tables.Comments) tables.Comments {
+		replies := children[comment.ID]
+		sort.Slice(replies, func(i, j int) bool { return replies[i].CreatedAt.Before(replies[j].CreatedAt) })
+		comment.Replies = make([]tables.Comments, 0, len(replies))
+		for _, reply := range replies {
+			comment.Replies = append(comment.Replies, attach(reply))
+		}
+		return comment
+	}
+
+	tree := make([]tables.Comments, 0, len(roots))
+	for _, root := range roots {
+		tree = append(tree, attach(root))
+	}
+	return tree
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+const customFieldDateLayout = "2006-01-02"
+
+var customFieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// customFieldError is a custom field value that doesn't match its
+// definition. It is returned from inside transactions, so callers can tell it
+// apart from a database error.
+type customFieldError struct {
+	msg string
+}
+
+func (e *customFieldError) Error() string {
+	return e.msg
+}
+
+func invalidCustomField(format string, args ...interface{}) error {
+	return &customFieldError{msg: fmt.Sprintf(format, args...)}
+}
+
+// parseCustomFieldValue checks a value sent by a client against its
+// definition and returns it as it is stored.
+func parseCustomFieldValue(def tables.CustomFieldDefinitions, raw json.RawMessage) (interface{}, error) {
+	switch def.Type {
+	case tables.FieldNumber:
+		var number float64
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return nil, invalidCustomField("Custom field %q must be a number", def.Key)
+		}
+		return number, nil
+	case tables.FieldDate:
+		var date string
+		if err := json.Unmarshal(raw, &date); err != nil {
+			return nil, invalidCustomField("Custom field %q must be a date (YYYY-MM-DD)", def.Key)
+		}
+		if _, err := time.Parse(customFieldDateLayout, date); err != nil {
+			return nil, invalidCustomField("Custom field %q must be a date (YYYY-MM-DD)", def.Key)
+		}
+		return date, nil
+	case tables.FieldEnum:
+		var option string
+		if err := json.Unmarshal(raw, &option); err == nil {
+			for _, allowed := range def.OptionList() {
+				if option == allowed {
+					return option, nil
+				}
+			}
+		}
+		return nil, invalidCustomField("Custom field %q must be one of: %s", def.Key, strings.Join(def.OptionList(), ", "))
+	default:
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil, invalidCustomField("Custom field %q must be a string", def.Key)
+		}
+		return text, nil
+	}
+}
+
+// resolveCustomFields returns the custom field values a complaint in the
+// category should store. Of current, the complaint's stored values, only the
+// keys the category defines are kept. input are the values sent by the
+// client, where null removes a value. Every key in input must be defined for
+// the category, and every required field must end up with a value.
+func resolveCustomFields(db *gorm.DB, categoryID uint, current tables.CustomFieldValues, input map[string]json.RawMessage) (tables.CustomFieldValues, error) {
+	var defs []tables.CustomFieldDefinitions
+	if err := db.Where("category_id = ?", categoryID).Find(&defs).Error; err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]tables.CustomFieldDefinitions, len(defs))
+	for _, def := range defs {
+		byKey[def.Key] = def
+	}
+
+	values := tables.CustomFieldValues{}
+	for key, value := range current {
+		if _, ok := byKey[key]; ok {
+			values[key] = value
+		}
+	}
+	for key, raw := range input {
+		def, ok := byKey[key]
+		if !ok {
+			return nil, invalidCustomField("Unknown custom field %q for this category", key)
+		}
+		if isNull(raw) {
+			delete(values, key)
+			continue
+		}
+		value, err := parseCustomFieldValue(def, raw)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			delete(values, key)
+			continue
+		}
+		values[key] = value
+	}
+
+	for _, def := range defs {
+		if _, ok := values[def.Key]; def.Required && !ok {
+			return nil, invalidCustomField("Custom field %q is required", def.Key)
+		}
+	}
+	return values, nil
+}
+
+type CustomFieldBody struct {
+	CategoryID uint     `json:"category"`
+	Key        string   `json:"key"`
+	Label      string   `json:"label"`
+	Type       string   `json:"type"`
+	Options    []string `json:"options"`
+	Required   bool     `json:"required"`
+}
+
+// customFieldOptions checks the options of a field and joins them for
+// storage. Only enum fields have options, and they need at least one.
+func customFieldOptions(fieldType string, options []string) (string, error) {
+	if fieldType != tables.FieldEnum {
+		if len(options) > 0 {
+			return "", errors.New("Only enum fields have options")
+		}
+		return "", nil
+	}
+	if len(options) == 0 {
+		return "", errors.New("Enum fields need at least one option")
+	}
+	seen := map[string]bool{}
+	for i, option := range options {
+		option = strings.TrimSpace(option)
+		if option == "" || strings.Contains(option, ",") || seen[option] {
+			return "", errors.New("Options must be distinct, non-empty and without commas")
+		}
+		seen[option] = true
+		options[i] = option
+	}
+	return strings.Join(options, ","), nil
+}
+
+func (h *Handlers) CreateCustomField(c *fiber.Ctx) error {
+	var body CustomFieldBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if !customFieldKeyPattern.MatchString(body.Key) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Key must be at most 40 lowercase letters, digits and underscores, starting with a letter",
+		})
+	}
+	switch body.Type {
+	case tables.FieldText, tables.FieldNumber, tables.FieldDate, tables.FieldEnum:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid field type. Must be text, number, date or enum.",
+		})
+	}
+	options, err := customFieldOptions(body.Type, body.Options)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var count int64
+	if err := h.db.Model(&tables.Categories{}).Where("id = ?", body.CategoryID).Count(&count).Error; err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load category",
+			"msg":   err.Error(),
+		})
+	}
+	if count == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Category does not exist",
+		})
+	}
+
+	if body.Label == "" {
+		body.Label = body.Key
+	}
+	field := tables.CustomFieldDefinitions{
+		CategoryID: body.CategoryID,
+		Key:        body.Key,
+		Label:      body.Label,
+		Type:       body.Type,
+		Options:    options,
+		Required:   body.Required,
+	}
+	if err := h.db.Create(&field).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key value") {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The category already has a field with this key",
+			})
+		}
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create custom field",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Custom field created successfully",
+		"fieldid": field.ID,
+	})
+}
+
+// EditCustomField changes the label, options and required flag of a field.
+// The category, key and type are fixed, since stored values depend on them.
+// Making a field required doesn't touch existing complaints; the rule applies
+// the next time their custom fields or category change.
+func (h *Handlers) EditCustomField(c *fiber.Ctx) error {
+	fieldID := c.Params("id")
+	var body CustomFieldBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	var field tables.CustomFieldDefinitions
+	if err := h.db.First(&field, fieldID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Custom field not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load custom field",
+		})
+	}
+	if (body.CategoryID != 0 && body.CategoryID != field.CategoryID) ||
+		(body.Key != "" && body.Key != field.Key) ||
+		(body.Type != "" && body.Type != field.Type) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The category, key and type of a custom field can't be changed",
+		})
+	}
+	options, err := customFieldOptions(field.Type, body.Options)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if body.Label != "" {
+		field.Label = body.Label
+	}
+	field.Options = options
+	field.Required = body.Required
+	if err := h.db.Save(&field).Error; err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update custom field",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Custom field updated successfully",
+		"fieldid": field.ID,
+	})
+}
+
+// DeleteCustomField removes a field and its values from every complaint in
+// the category, recording the removed values in the complaint history.
+func (h *Handlers) DeleteCustomField(c *fiber.Ctx) error {
+	fieldID := c.Params("id")
+
+	var field tables.CustomFieldDefinitions
+	if err := h.db.First(&field, fieldID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Custom field not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load custom field",
+		})
+	}
+
+	userID, _ := currentUserID(c)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO complaint_histories (complaint_id, field, old_value, new_value, changed_by_id, batch_id, created_at)
+			SELECT id, ?, custom_fields ->> ?, '', ?, '', NOW() FROM complaints
+			WHERE category_id = ? AND custom_fields ->> ? IS NOT NULL`,
+			customFieldHistoryField(field.Key), field.Key, userID, field.CategoryID, field.Key).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE complaints SET custom_fields = custom_fields - ?, version = version + 1
+			WHERE category_id = ? AND custom_fields ->> ? IS NOT NULL`,
+			field.Key, field.CategoryID, field.Key).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&field).Error
+	})
+
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete custom field",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Custom field deleted successfully",
+		"fieldid": field.ID,
+	})
+}
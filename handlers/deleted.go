@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+// DeleteComplaint soft-deletes a complaint and its comments. The comments get
+// the same deletion timestamp as the complaint so a restore brings back
+// exactly the comments that were deleted with it. Only the creator of the
+// complaint or an admin may delete it.
+func (h *Handlers) DeleteComplaint(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+	userID, _ := currentUserID(c)
+
+	var complaint tables.Complaints
+	if err := h.db.First(&complaint, complaintID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+		})
+	}
+
+	if complaint.CreatedByID != userID && !isAdmin(c) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the creator of a complaint or an admin can delete it",
+		})
+	}
+
+	now := time.Now()
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&tables.Comments{}).
+			Where("complaint_id = ? AND deleted_at IS NULL", complaint.ID).
+			Updates(map[string]interface{}{
+				"deleted_at":    now,
+				"deleted_by_id": userID,
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&complaint).Updates(map[string]interface{}{
+			"deleted_at":    now,
+			"deleted_by_id": userID,
+			"version":       gorm.Expr("version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		return recordComplaintEvent(tx, events.ComplaintDeleted, complaint, userID, 0)
+	})
+
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete complaint",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaint deleted successfully",
+		"complaintid": complaint.ID,
+	})
+}
+
+func (h *Handlers) GetDeletedComplaints(c *fiber.Ctx) error {
+	var complaints []tables.Complaints
+	result := h.db.Unscoped().
+		Preload("CreatedBy", withDeleted).
+		Preload("Customer").
+		Preload("Category").
+		Where("complaints.deleted_at IS NOT NULL").
+		Order("complaints.deleted_at DESC").
+		Find(&complaints)
+
+	if result.Error != nil {
+		fmt.Println("Database error:", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get deleted complaints",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(complaints)
+}
+
+// RestoreComplaint undeletes a complaint together with the comments that were
+// deleted along with it. Comments deleted on their own before the complaint
+// stay deleted.
+func (h *Handlers) RestoreComplaint(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+	userID, _ := currentUserID(c)
+
+	var complaint tables.Complaints
+	if err := h.db.Unscoped().Where("deleted_at IS NOT NULL").First(&complaint, complaintID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Deleted complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+		})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&tables.Comments{}).
+			Where("complaint_id = ? AND deleted_at = ?", complaint.ID, complaint.DeletedAt.Time).
+			Updates(map[string]interface{}{
+				"deleted_at":    nil,
+				"deleted_by_id": nil,
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&complaint).Updates(map[string]interface{}{
+			"deleted_at":    nil,
+			"deleted_by_id": nil,
+			"version":       gorm.Expr("version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		return recordComplaintEvent(tx, events.ComplaintRestored, complaint, userID, 0)
+	})
+
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to restore complaint",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaint restored successfully",
+		"complaintid": complaint.ID,
+	})
+}
+
+// RunPurge permanently removes complaints that have been deleted for longer
+// than retention, checking once an hour. A retention of zero or less
+// disables purging.
+func (h *Handlers) RunPurge(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := purgeDeletedComplaints(h.db.WithContext(ctx), time.Now().Add(-retention))
+			if err != nil {
+				log.Println("Complaint purge error:", err)
+			} else if n > 0 {
+				log.Printf("Purged %d deleted complaints", n)
+			}
+		}
+	}
+}
+
+// purgeDeletedComplaints deletes complaints deleted before cutoff and
+// everything that belongs to them.
+func purgeDeletedComplaints(db *gorm.DB, cutoff time.Time) (int64, error) {
+	var purged int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		complaints := tx.Unscoped().Model(&tables.Complaints{}).Select("id").
+			Where("deleted_at < ?", cutoff)
+		comments := tx.Model(&tables.Comments{}).Select("id").
+			Where("complaint_id IN (?)", complaints)
+
+		if err := tx.Where("comment_id IN (?)", comments).Delete(&tables.CommentMentions{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("comment_id IN (?)", comments).Delete(&tables.CommentRevisions{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("complaint_id IN (?)", complaints).Delete(&tables.Comments{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("complaint_id IN (?)", complaints).Delete(&tables.ComplaintWatchers{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("complaint_id IN (?)", complaints).Delete(&tables.Notifications{}).Error; err != nil {
+			return err
+		}
+		// Outbox events and the emails rendered from them quote descriptions
+		// and comments, so they go too.
+		outboxEvents := tx.Model(&tables.OutboxEvents{}).Select("id").
+			Where("complaint_id IN (?)", complaints)
+		if err := tx.Where("event_id IN (?)", outboxEvents).Delete(&tables.EmailNotifications{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("event_id IN (?)", outboxEvents).Delete(&tables.OutboxDeliveries{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("complaint_id IN (?)", complaints).Delete(&tables.OutboxEvents{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("complaint_id IN (?)", complaints).Delete(&tables.ComplaintHistory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("complaint_id IN (?)", complaints).Delete(&tables.ComplaintTags{}).Error; err != nil {
+			return err
+		}
+
+		merges := tx.Model(&tables.ComplaintMerges{}).Select("id").
+			Where("primary_id IN (?) OR duplicate_id IN (?)", complaints, complaints)
+		if err := tx.Where("merge_id IN (?)", merges).Delete(&tables.ComplaintMergeComments{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("merge_id IN (?)", merges).Delete(&tables.ComplaintMergeWatchers{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("primary_id IN (?) OR duplicate_id IN (?)", complaints, complaints).Delete(&tables.ComplaintMerges{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("source_id IN (?) OR target_id IN (?)", complaints, complaints).Delete(&tables.ComplaintLinks{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&tables.Complaints{}).Where("merged_into_id IN (?)", complaints).
+			Update("merged_into_id", nil).Error; err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&tables.Complaints{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+const maxDuplicateCandidates = 5
+
+type DuplicateCandidate struct {
+	ComplaintID uint          `json:"complaintid"`
+	Reference   string        `json:"reference"`
+	Description string        `json:"description"`
+	Status      tables.Status `json:"status"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	Similarity  float64       `json:"similarity"`
+}
+
+// findDuplicates returns the customer's recent complaints whose description
+// is similar to description, most similar first. Complaints that were already
+// merged into another one are left out.
+func (h *Handlers) findDuplicates(customerID uint, description string) ([]DuplicateCandidate, error) {
+	candidates := []DuplicateCandidate{}
+	since := time.Now().AddDate(0, 0, -h.config.DuplicateWindowDays)
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// The % operator uses the trigram index, with the threshold set
+		// for this transaction only.
+		if err := tx.Exec("SELECT set_config('pg_trgm.similarity_threshold', ?, true)",
+			strconv.FormatFloat(h.config.DuplicateThreshold, 'f', -1, 64)).Error; err != nil {
+			return err
+		}
+		return tx.Model(&tables.Complaints{}).
+			Select("id AS complaint_id, reference, description, status, created_at, similarity(description, ?) AS similarity", description).
+			Where("customer_id = ? AND created_at >= ? AND merged_into_id IS NULL", customerID, since).
+			Where("description % ?", description).
+			Order("similarity DESC").
+			Limit(maxDuplicateCandidates).
+			Scan(&candidates).Error
+	})
+	return candidates, err
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errVersionConflict = errors.New("version conflict")
+
+// etag formats a row version as a strong ETag.
+func etag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// ifMatch is a parsed If-Match header: either "*" or a list of ETags.
+type ifMatch struct {
+	any      bool
+	versions []uint
+}
+
+// matches reports whether a resource at the given version satisfies the
+// header. "*" matches any current version (RFC 9110, section 13.1.1).
+func (m ifMatch) matches(version uint) bool {
+	if m.any {
+		return true
+	}
+	for _, v := range m.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIfMatch reads the If-Match header. ok is false when the header is
+// missing. Values that aren't one of our ETags are ignored, so they never
+// match.
+func parseIfMatch(c *fiber.Ctx) (match ifMatch, ok bool) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" {
+		return match, false
+	}
+	if header == "*" {
+		return ifMatch{any: true}, true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		v, err := strconv.ParseUint(strings.Trim(tag, `"`), 10, 64)
+		if err == nil && v > 0 {
+			match.versions = append(match.versions, uint(v))
+		}
+	}
+	return match, true
+}
+
+// requireIfMatch writes a 428 response when the If-Match header is missing.
+// When ok is false the response has been written and should be returned.
+func requireIfMatch(c *fiber.Ctx) (match ifMatch, ok bool, err error) {
+	match, ok = parseIfMatch(c)
+	if !ok {
+		return match, false, c.Status(fiber.StatusPreconditionRequired).JSON(fiber.Map{
+			"error": "If-Match header with the current ETag is required",
+		})
+	}
+	return match, true, nil
+}
+
+// versionConflict responds with 412 and the current state of the resource so
+// the client can merge its change and retry.
+func versionConflict(c *fiber.Ctx, current interface{}, version uint) error {
+	c.Set(fiber.HeaderETag, etag(version))
+	return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+		"error":   "The resource was changed by someone else",
+		"current": current,
+	})
+}
+
+// saveVersioned writes every column of value, but only if the row is still at
+// the expected version. value's Version must already be set to the new
+// version. errVersionConflict is returned when someone else got there first.
+func saveVersioned(tx *gorm.DB, value interface{}, expected uint) error {
+	result := tx.Model(value).
+		Where("version = ?", expected).
+		Select("*").
+		Omit(clause.Associations, "created_at").
+		Updates(value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errVersionConflict
+	}
+	return nil
+}
@@ -1,9 +1,9 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -11,23 +11,46 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/auth"
 	"github.com/pedersandvoll/Practice-Exam-BE/config"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/notify"
 	"github.com/pedersandvoll/Practice-Exam-BE/tables"
 	"github.com/pedersandvoll/Practice-Exam-BE/utils"
 )
 
 type Handlers struct {
-	db        *config.Database
-	JWTSecret []byte
+	db     *config.Database
+	config *config.Config
+	oidc   *auth.OIDCProvider
+	events *events.Broker
+	mailer notify.Mailer
+	Keys   *auth.KeySet
 }
 
-func NewHandlers(db *config.Database, jwtSecret string) *Handlers {
+func NewHandlers(db *config.Database, cfg *config.Config, keys *auth.KeySet, oidc *auth.OIDCProvider, broker *events.Broker, mailer notify.Mailer) *Handlers {
 	return &Handlers{
-		db:        db,
-		JWTSecret: []byte(jwtSecret),
+		db:     db,
+		config: cfg,
+		oidc:   oidc,
+		events: broker,
+		mailer: mailer,
+		Keys:   keys,
 	}
 }
 
+// currentUserID returns the ID of the authenticated caller, whether they
+// authenticated with a JWT (where the claim is a float64) or an API key.
+func currentUserID(c *fiber.Ctx) (uint, bool) {
+	switch id := c.Locals("userid").(type) {
+	case float64:
+		return uint(id), true
+	case uint:
+		return id, true
+	}
+	return 0, false
+}
+
 type UserBody struct {
 	Email    string `json:"email"`
 	Name     string `json:"name"`
@@ -35,6 +58,12 @@ type UserBody struct {
 }
 
 func (h *Handlers) RegisterUser(c *fiber.Ctx) error {
+	if !h.config.LocalLogin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Local registration is disabled, use single sign-on",
+		})
+	}
+
 	var body UserBody
 
 	if err := c.BodyParser(&body); err != nil {
@@ -60,6 +89,7 @@ func (h *Handlers) RegisterUser(c *fiber.Ctx) error {
 		Email:    body.Email,
 		Name:     body.Name,
 		Password: hashedPassword,
+		Role:     tables.RoleAgent,
 	}
 	result := h.db.DB.Create(&user)
 
@@ -88,6 +118,12 @@ type LoginBody struct {
 }
 
 func (h *Handlers) LoginUser(c *fiber.Ctx) error {
+	if !h.config.LocalLogin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Local login is disabled, use single sign-on",
+		})
+	}
+
 	var body LoginBody
 
 	if err := c.BodyParser(&body); err != nil {
@@ -126,26 +162,39 @@ func (h *Handlers) LoginUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if !user.Active {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "User is deactivated",
+		})
+	}
+
+	t, err := h.issueToken(user)
+	if err != nil {
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return c.JSON(fiber.Map{"token": t})
+}
+
+func (h *Handlers) issueToken(user tables.Users) (string, error) {
 	claims := jwt.MapClaims{
 		"username": user.Name,
 		"userid":   user.ID,
 		"email":    user.Email,
+		"role":     user.Role,
 		"exp":      time.Now().Add(time.Hour * 24).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	t, err := token.SignedString(h.JWTSecret)
-	if err != nil {
-		return c.SendStatus(fiber.StatusInternalServerError)
-	}
+	return h.Keys.Sign(claims)
+}
 
-	return c.JSON(fiber.Map{"token": t})
+func (h *Handlers) GetJWKS(c *fiber.Ctx) error {
+	return c.JSON(h.Keys.JWKS())
 }
 
 func (h *Handlers) GetUsers(c *fiber.Ctx) error {
 	var users []tables.Users
-	result := h.db.DB.Find(&users)
+	result := h.db.DB.Where("active = ?", true).Find(&users)
 
 	if result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -154,7 +203,7 @@ func (h *Handlers) GetUsers(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(users)
+	return c.JSON(newUserResponses(users))
 }
 
 type CustomerBody struct {
@@ -215,13 +264,116 @@ func (h *Handlers) GetCustomers(c *fiber.Ctx) error {
 	return c.JSON(customers)
 }
 
+func (h *Handlers) EditCustomer(c *fiber.Ctx) error {
+	customerID := c.Params("id")
+	if customerID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID is required in the URL",
+		})
+	}
+	var body CustomerBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Customer name is required",
+		})
+	}
+
+	match, ok, err := requireIfMatch(c)
+	if !ok {
+		return err
+	}
+
+	var customer tables.Customers
+	if err := h.db.First(&customer, customerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load customer",
+		})
+	}
+	if !match.matches(customer.Version) {
+		return versionConflict(c, customer, customer.Version)
+	}
+	expected := customer.Version
+
+	customer.Name = body.Name
+	customer.Version = expected + 1
+	err = saveVersioned(h.db.DB, &customer, expected)
+	if errors.Is(err, errVersionConflict) {
+		h.db.First(&customer, customerID)
+		return versionConflict(c, customer, customer.Version)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value") {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Customer already exists",
+			})
+		}
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update customer",
+			"msg":   err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderETag, etag(customer.Version))
+	return c.JSON(fiber.Map{
+		"message":    "Customer updated successfully",
+		"customerid": customer.ID,
+	})
+}
+
 type ComplaintsBody struct {
 	CustomerName  string          `json:"customername"`
 	Description   string          `json:"description"`
 	CategoryId    uint            `json:"category"`
+	AssigneeID    *uint           `json:"assignee"`
 	Priority      tables.Priority `json:"priority"`
 	Status        tables.Status   `json:"status"`
 	ComplaintDate time.Time       `json:"date"`
+	// CustomFields are the values of the category's custom fields.
+	CustomFields map[string]json.RawMessage `json:"customFields"`
+}
+
+// customFieldsFailed answers a failed custom field check, which is the
+// client's fault unless the definitions couldn't be loaded.
+func customFieldsFailed(c *fiber.Ctx, err error) error {
+	var fieldErr *customFieldError
+	if errors.As(err, &fieldErr) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	fmt.Println("Database error:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to load custom fields",
+		"msg":   err.Error(),
+	})
+}
+
+// validAssignee reports whether id refers to an active user. A nil id means
+// unassigned and is always valid.
+func (h *Handlers) validAssignee(id *uint) bool {
+	if id == nil {
+		return true
+	}
+	var count int64
+	h.db.Model(&tables.Users{}).Where("id = ? AND active = ?", *id, true).Count(&count)
+	return count > 0
+}
+
+// withDeleted is used when preloading authors so complaints and comments by
+// deleted users still show who wrote them.
+func withDeleted(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
 }
 
 func (h *Handlers) RegisterComplaint(c *fiber.Ctx) error {
@@ -232,26 +384,62 @@ func (h *Handlers) RegisterComplaint(c *fiber.Ctx) error {
 		})
 	}
 
+	// checkDuplicates=true only previews the likely duplicates of the
+	// complaint without creating it.
+	if c.QueryBool("checkDuplicates") {
+		if body.CustomerName == "" || body.Description == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Customer name and description are required",
+			})
+		}
+		duplicates := []DuplicateCandidate{}
+		var customer tables.Customers
+		if err := h.db.Where("name = ?", body.CustomerName).Limit(1).Find(&customer).Error; err != nil {
+			fmt.Println("Database error:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check for duplicates",
+				"msg":   err.Error(),
+			})
+		}
+		if customer.ID != 0 {
+			found, err := h.findDuplicates(customer.ID, body.Description)
+			if err != nil {
+				fmt.Println("Database error:", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check for duplicates",
+					"msg":   err.Error(),
+				})
+			}
+			duplicates = found
+		}
+		return c.JSON(fiber.Map{
+			"duplicates": duplicates,
+		})
+	}
+
 	if body.CustomerName == "" || body.Description == "" || body.CategoryId == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Missing required fields",
 		})
 	}
 
-	token := c.Locals("user").(*jwt.Token)
-	if token == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized - Missing JWT token",
+	if !h.validAssignee(body.AssigneeID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Assignee does not exist or is deactivated",
 		})
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	userIDFloat, ok := claims["userid"].(float64)
+
+	customFields, err := resolveCustomFields(h.db.DB, body.CategoryId, nil, body.CustomFields)
+	if err != nil {
+		return customFieldsFailed(c, err)
+	}
+
+	userID, ok := currentUserID(c)
 	if !ok {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid User ID type in JWT",
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
 		})
 	}
-	userID := uint(userIDFloat)
 
 	var customer tables.Customers
 	resultCustomer := h.db.Where("name = ?", body.CustomerName).First(&customer)
@@ -277,44 +465,73 @@ func (h *Handlers) RegisterComplaint(c *fiber.Ctx) error {
 		}
 	}
 
+	// Possible duplicates are only a warning, so a failed check doesn't stop
+	// the complaint from being created.
+	duplicates, err := h.findDuplicates(customer.ID, body.Description)
+	if err != nil {
+		fmt.Println("Duplicate check error:", err)
+		duplicates = []DuplicateCandidate{}
+	}
+
 	complaint := tables.Complaints{
 		CustomerID:    customer.ID,
 		Description:   body.Description,
 		CreatedByID:   userID,
+		AssigneeID:    body.AssigneeID,
 		CategoryId:    body.CategoryId,
 		Priority:      body.Priority,
 		Status:        body.Status,
 		ComplaintDate: body.ComplaintDate,
+		CustomFields:  customFields,
 	}
-	result := h.db.DB.Create(&complaint)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		reference, err := nextReference(tx)
+		if err != nil {
+			return err
+		}
+		complaint.Reference = reference
+		if err := tx.Create(&complaint).Error; err != nil {
+			return err
+		}
+		if err := follow(tx, complaint.ID, &userID); err != nil {
+			return err
+		}
+		if err := follow(tx, complaint.ID, complaint.AssigneeID); err != nil {
+			return err
+		}
+		return recordComplaintEvent(tx, events.ComplaintCreated, complaint, userID, 0)
+	})
 
-	if result.Error != nil {
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create complaint",
-			"msg":   result.Error.Error(),
+			"msg":   err.Error(),
 		})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"message":     "Complaint created successfully",
-		"complaintid": complaint.ID,
+		"message":            "Complaint created successfully",
+		"complaintid":        complaint.ID,
+		"reference":          complaint.Reference,
+		"possibleDuplicates": duplicates,
 	})
 }
 
 type EditComplaintBody struct {
 	Description   string          `json:"description"`
 	CategoryId    uint            `json:"category"`
+	AssigneeID    *uint           `json:"assignee"`
 	Priority      tables.Priority `json:"priority"`
 	Status        tables.Status   `json:"status"`
 	ComplaintDate time.Time       `json:"date"`
+	// CustomFields replaces all custom field values when it is sent.
+	CustomFields map[string]json.RawMessage `json:"customFields"`
 }
 
 func (h *Handlers) EditComplaint(c *fiber.Ctx) error {
-	complaintID := c.Params("id")
-	if complaintID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID is required in the URL",
-		})
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
 	}
 	var body EditComplaintBody
 	if err := c.BodyParser(&body); err != nil {
@@ -351,6 +568,17 @@ func (h *Handlers) EditComplaint(c *fiber.Ctx) error {
 		}
 	}
 
+	if !h.validAssignee(body.AssigneeID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Assignee does not exist or is deactivated",
+		})
+	}
+
+	match, ok, err := requireIfMatch(c)
+	if !ok {
+		return err
+	}
+
 	var complaint tables.Complaints
 	result := h.db.First(&complaint, complaintID)
 	if result.Error != nil {
@@ -363,43 +591,91 @@ func (h *Handlers) EditComplaint(c *fiber.Ctx) error {
 			"error": "Failed to load complaint",
 		})
 	}
+	if !match.matches(complaint.Version) {
+		return versionConflict(c, complaint, complaint.Version)
+	}
+	expected := complaint.Version
 
+	before := complaint
+	if body.CustomFields != nil || body.CategoryId != complaint.CategoryId {
+		current := complaint.CustomFields
+		if body.CustomFields != nil {
+			current = nil
+		}
+		values, err := resolveCustomFields(h.db.DB, body.CategoryId, current, body.CustomFields)
+		if err != nil {
+			return customFieldsFailed(c, err)
+		}
+		complaint.CustomFields = values
+	}
 	complaint.Description = body.Description
 	complaint.Priority = body.Priority
 	complaint.Status = body.Status
 	complaint.CategoryId = body.CategoryId
 	complaint.ComplaintDate = body.ComplaintDate
+	assigned := body.AssigneeID != nil && (complaint.AssigneeID == nil || *complaint.AssigneeID != *body.AssigneeID)
+	if body.AssigneeID != nil {
+		complaint.AssigneeID = body.AssigneeID
+		complaint.Assignee = nil
+	}
 
-	result = h.db.Save(&complaint)
-	if result.Error != nil {
+	complaint.Version = expected + 1
+
+	userID, _ := currentUserID(c)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &complaint, expected); err != nil {
+			return err
+		}
+		if err := recordHistory(tx, complaint.ID, complaintChanges(before, complaint), userID, ""); err != nil {
+			return err
+		}
+		if err := recordComplaintEvent(tx, events.ComplaintUpdated, complaint, userID, 0); err != nil {
+			return err
+		}
+		if assigned {
+			if err := follow(tx, complaint.ID, complaint.AssigneeID); err != nil {
+				return err
+			}
+			return recordComplaintEvent(tx, events.ComplaintAssigned, complaint, userID, 0)
+		}
+		return nil
+	})
+
+	if errors.Is(err, errVersionConflict) {
+		return h.complaintConflict(c, complaint.ID)
+	}
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update complaint",
-			"msg":   result.Error.Error(),
+			"msg":   err.Error(),
 		})
 	}
 
+	c.Set(fiber.HeaderETag, etag(complaint.Version))
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message":     "Complaint updated successfully",
-		"complaintid": complaintID,
+		"complaintid": complaint.ID,
 	})
 }
 
 func (h Handlers) GetComplaintById(c *fiber.Ctx) error {
-	complaintID := c.Params("id")
-	if complaintID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID is required in the URL",
-		})
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
 	}
 	var complaint tables.Complaints
 	result := h.db.
-		Preload("CreatedBy").
+		Preload("CreatedBy", withDeleted).
+		Preload("Assignee", withDeleted).
 		Preload("Customer").
 		Preload("Comments", func(db *gorm.DB) *gorm.DB {
-			return db.Order("comments.created_at DESC") // Sort by CreatedAt in descending order
+			return visibleComments(c, db).Order("comments.created_at DESC") // Sort by CreatedAt in descending order
 		}).
-		Preload("Comments.CreatedBy").
+		Preload("Comments.CreatedBy", withDeleted).
+		Preload("Comments.Mentions").
 		Preload("Category").
+		Preload("Watchers").
+		Preload("Tags").
 		First(&complaint, complaintID)
 
 	if result.Error != nil {
@@ -409,31 +685,154 @@ func (h Handlers) GetComplaintById(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(complaint)
+	if c.QueryBool("threaded") {
+		complaint.Comments = buildCommentTree(complaint.Comments)
+	}
+
+	links, err := linkedComplaints(h.db.DB, complaint.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get linked complaints",
+			"msg":   err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderETag, etag(complaint.Version))
+
+	return c.JSON(struct {
+		tables.Complaints
+		Links []LinkedComplaint
+	}{complaint, links})
+}
+
+// ComplaintFilter holds the filters shared by the complaint list and bulk
+// updates. Zero values mean "don't filter".
+type ComplaintFilter struct {
+	UserID      uint   `json:"userId" query:"userId"`
+	CustomerID  uint   `json:"customerId" query:"customerId"`
+	AssigneeID  uint   `json:"assigneeId" query:"assigneeId"`
+	MentionsMe  bool   `json:"mentionsMe" query:"mentionsMe"`
+	SearchValue string `json:"searchValue" query:"searchValue"`
+	// Tags is a comma-separated list of tag names. TagMatch is "any" (the
+	// default) to match complaints with at least one of them, or "all".
+	Tags     string `json:"tags" query:"tags"`
+	TagMatch string `json:"tagMatch" query:"tagMatch"`
+	// CustomFields matches custom field values exactly, by key. In a query
+	// string they are given as cf.<key>=<value>.
+	CustomFields map[string]string `json:"customFields" query:"-"`
+}
+
+// parseComplaintFilter reads a ComplaintFilter from the query string.
+func parseComplaintFilter(c *fiber.Ctx) (ComplaintFilter, error) {
+	var filter ComplaintFilter
+	if err := c.QueryParser(&filter); err != nil {
+		return filter, err
+	}
+	for key, value := range c.Queries() {
+		if strings.HasPrefix(key, "cf.") {
+			if filter.CustomFields == nil {
+				filter.CustomFields = map[string]string{}
+			}
+			filter.CustomFields[strings.TrimPrefix(key, "cf.")] = value
+		}
+	}
+	return filter, nil
+}
+
+// isEmpty reports whether the filter matches every complaint.
+func (f ComplaintFilter) isEmpty() bool {
+	return f.UserID == 0 && f.CustomerID == 0 && f.AssigneeID == 0 && !f.MentionsMe &&
+		f.SearchValue == "" && len(f.tagNames()) == 0 && len(f.CustomFields) == 0
+}
+
+// tagNames splits the Tags filter into lower-cased names.
+func (f ComplaintFilter) tagNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(f.Tags, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// apply adds the filter to a complaints query. userID is the caller, used by
+// MentionsMe.
+func (f ComplaintFilter) apply(query *gorm.DB, userID uint) *gorm.DB {
+	if f.UserID != 0 {
+		query = query.Where("complaints.created_by_id = ?", f.UserID)
+	}
+	if f.AssigneeID != 0 {
+		query = query.Where("complaints.assignee_id = ?", f.AssigneeID)
+	}
+	if f.MentionsMe {
+		query = query.Where(`EXISTS (SELECT 1 FROM comments
+			JOIN comment_mentions ON comment_mentions.comment_id = comments.id
+			WHERE comments.complaint_id = complaints.id AND comments.deleted_at IS NULL
+			AND comment_mentions.user_id = ?)`, userID)
+	}
+	if f.CustomerID != 0 {
+		query = query.Where("complaints.customer_id = ?", f.CustomerID)
+	}
+	if f.SearchValue != "" {
+		search := "%" + strings.ToLower(f.SearchValue) + "%"
+		query = query.Where("(LOWER(complaints.description) LIKE ? OR LOWER(complaints.reference) LIKE ?)", search, search)
+	}
+	if names := f.tagNames(); len(names) > 0 {
+		tagged := `SELECT COUNT(DISTINCT tags.id) FROM complaint_tags
+			JOIN tags ON tags.id = complaint_tags.tag_id
+			WHERE complaint_tags.complaint_id = complaints.id AND LOWER(tags.name) IN ?`
+		if f.TagMatch == "all" {
+			query = query.Where("("+tagged+") = ?", names, len(names))
+		} else {
+			query = query.Where("("+tagged+") > 0", names)
+		}
+	}
+	for key, value := range f.CustomFields {
+		query = query.Where("complaints.custom_fields ->> ? = ?", key, value)
+	}
+	return query
 }
 
 func (h *Handlers) GetComplaints(c *fiber.Ctx) error {
-	userId := c.Query("userId")
-	customerId := c.Query("customerId")
 	sortBy := c.Query("sortBy", "created_at")
 	sortOrder := c.Query("sortOrder", "desc")
-	searchValue := c.Query("searchValue")
 
-	query := h.db.
-		Preload("CreatedBy").
-		Preload("Customer").
-		Preload("Comments").
-		Preload("Comments.CreatedBy").
-		Preload("Category")
-	if userId != "" {
-		query = query.Where("created_by_id = ?", userId)
+	filter, err := parseComplaintFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid query parameters",
+			"msg":   err.Error(),
+		})
 	}
-	if customerId != "" {
-		query = query.Where("customer_id = ?", customerId)
+
+	// Comments are only loaded on request; by default each complaint carries
+	// the number of comments the caller can see and when the last one was made.
+	commentFilter := "comments.complaint_id = complaints.id AND comments.deleted_at IS NULL"
+	if !canSeeInternal(c) {
+		commentFilter += " AND comments.visibility = '" + tables.VisibilityPublic + "'"
 	}
-	if searchValue != "" {
-		query = query.Where("LOWER(description) LIKE ?", "%"+strings.ToLower(searchValue)+"%")
+	query := h.db.
+		Select("complaints.*, "+
+			"(SELECT COUNT(*) FROM comments WHERE "+commentFilter+") AS comment_count, "+
+			"(SELECT MAX(comments.created_at) FROM comments WHERE "+commentFilter+") AS last_comment_at").
+		Preload("CreatedBy", withDeleted).
+		Preload("Assignee", withDeleted).
+		Preload("Customer").
+		Preload("Category").
+		Preload("Tags")
+	if c.QueryBool("includeComments") {
+		query = query.
+			Preload("Comments", func(db *gorm.DB) *gorm.DB {
+				return visibleComments(c, db)
+			}).
+			Preload("Comments.CreatedBy", withDeleted).
+			Preload("Comments.Mentions")
 	}
+	userID, _ := currentUserID(c)
+	query = filter.apply(query, userID)
 
 	allowedSortColumns := map[string]bool{
 		"created_at":  true,
@@ -466,22 +865,19 @@ func (h *Handlers) GetComplaints(c *fiber.Ctx) error {
 }
 
 type CommentBody struct {
-	Comment string `json:"comment"`
+	Comment    string `json:"comment"`
+	Visibility string `json:"visibility"`
+	ParentID   *uint  `json:"parent"`
 }
 
-func (h *Handlers) AddComplaintComment(c *fiber.Ctx) error {
-	complaintIDStr := c.Params("id")
-	if complaintIDStr == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "ID is required in the URL",
-		})
-	}
+func validVisibility(visibility string) bool {
+	return visibility == tables.VisibilityInternal || visibility == tables.VisibilityPublic
+}
 
-	complaintID, err := strconv.ParseUint(complaintIDStr, 10, 64)
+func (h *Handlers) AddComplaintComment(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid complaint ID format",
-		})
+		return complaintIDFailed(c, err)
 	}
 
 	var body CommentBody
@@ -497,40 +893,100 @@ func (h *Handlers) AddComplaintComment(c *fiber.Ctx) error {
 		})
 	}
 
-	token := c.Locals("user").(*jwt.Token)
-	if token == nil {
-		fmt.Println("JWT token not found in locals")
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized - Missing JWT token",
+	// Comments are public unless explicitly made internal notes, as they
+	// were before internal notes existed, and callers who can't see
+	// internal notes can't write them either.
+	if body.Visibility == "" {
+		body.Visibility = tables.VisibilityPublic
+	}
+	if !canSeeInternal(c) {
+		body.Visibility = tables.VisibilityPublic
+	}
+	if !validVisibility(body.Visibility) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid visibility. Must be internal or public.",
 		})
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	userIDFloat, ok := claims["userid"].(float64)
+
+	userID, ok := currentUserID(c)
 	if !ok {
-		fmt.Println("Invalid User ID type in JWT.  Expected float64.")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	var complaint tables.Complaints
+	resultComplaint := h.db.First(&complaint, complaintID)
+	if resultComplaint.Error != nil {
+		if errors.Is(resultComplaint.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+			"msg":   resultComplaint.Error.Error(),
+		})
+	}
+
+	mentions, err := h.resolveMentions(body.Comment, body.Visibility)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid User ID type in JWT",
+			"error": "Failed to resolve mentions",
+			"msg":   err.Error(),
 		})
 	}
-	userID := uint(userIDFloat)
 
 	comment := tables.Comments{
-		ComplaintID: uint(complaintID),
+		ComplaintID: complaintID,
 		Comment:     body.Comment,
+		Visibility:  body.Visibility,
 		CreatedByID: userID,
 	}
-	result := h.db.DB.Create(&comment)
 
-	if result.Error != nil {
+	if body.ParentID != nil {
+		var parent tables.Comments
+		resultParent := visibleComments(c, h.db.DB).First(&parent, *body.ParentID)
+		if resultParent.Error != nil || parent.ComplaintID != complaint.ID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Parent comment does not exist on this complaint",
+			})
+		}
+		if parent.DeletedAt != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot reply to a deleted comment",
+			})
+		}
+		comment.ParentID = &parent.ID
+		comment.Depth = parent.Depth + 1
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		if err := saveMentions(tx, comment.ID, mentions); err != nil {
+			return err
+		}
+		comment.Mentions = mentions
+		if err := follow(tx, complaint.ID, &userID); err != nil {
+			return err
+		}
+		return recordCommentEvent(tx, events.CommentCreated, complaint, comment, userID)
+	})
+
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create comment",
-			"msg":   result.Error.Error(),
+			"msg":   err.Error(),
 		})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message":   "comment created successfully",
 		"commentid": comment.ID,
+		"parentid":  comment.ParentID,
+		"depth":     comment.Depth,
+		"mentions":  newMentionResponses(mentions),
 	})
 }
 
@@ -572,7 +1028,9 @@ func (h *Handlers) RegisterCategory(c *fiber.Ctx) error {
 
 func (h *Handlers) GetCategories(c *fiber.Ctx) error {
 	var categories []tables.Categories
-	result := h.db.DB.Find(&categories)
+	result := h.db.DB.Preload("CustomFields", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Find(&categories)
 
 	if result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -583,3 +1041,64 @@ func (h *Handlers) GetCategories(c *fiber.Ctx) error {
 
 	return c.JSON(categories)
 }
+
+func (h *Handlers) EditCategory(c *fiber.Ctx) error {
+	categoryID := c.Params("id")
+	if categoryID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID is required in the URL",
+		})
+	}
+	var body CategoryBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Category name is required",
+		})
+	}
+
+	match, ok, err := requireIfMatch(c)
+	if !ok {
+		return err
+	}
+
+	var category tables.Categories
+	if err := h.db.First(&category, categoryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Category not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load category",
+		})
+	}
+	if !match.matches(category.Version) {
+		return versionConflict(c, category, category.Version)
+	}
+	expected := category.Version
+
+	category.Name = body.Name
+	category.Version = expected + 1
+	err = saveVersioned(h.db.DB, &category, expected)
+	if errors.Is(err, errVersionConflict) {
+		h.db.First(&category, categoryID)
+		return versionConflict(c, category, category.Version)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update category",
+			"msg":   err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderETag, etag(category.Version))
+	return c.JSON(fiber.Map{
+		"message":    "Category updated successfully",
+		"categoryid": category.ID,
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+func formatOptionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// complaintChanges lists the fields that differ between two versions of a
+// complaint as history rows, without the complaint, author or batch filled in.
+func complaintChanges(before, after tables.Complaints) []tables.ComplaintHistory {
+	var changes []tables.ComplaintHistory
+	add := func(field, oldValue, newValue string) {
+		if oldValue != newValue {
+			changes = append(changes, tables.ComplaintHistory{Field: field, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	add("description", before.Description, after.Description)
+	add("priority", before.Priority.String(), after.Priority.String())
+	add("status", before.Status.String(), after.Status.String())
+	add("category", strconv.FormatUint(uint64(before.CategoryId), 10), strconv.FormatUint(uint64(after.CategoryId), 10))
+	add("assignee", formatOptionalID(before.AssigneeID), formatOptionalID(after.AssigneeID))
+	add("date", before.ComplaintDate.Format(time.RFC3339), after.ComplaintDate.Format(time.RFC3339))
+
+	keys := []string{}
+	for key := range before.CustomFields {
+		keys = append(keys, key)
+	}
+	for key := range after.CustomFields {
+		if _, ok := before.CustomFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(customFieldHistoryField(key), formatCustomFieldValue(before.CustomFields[key]), formatCustomFieldValue(after.CustomFields[key]))
+	}
+	return changes
+}
+
+// customFieldHistoryField names a custom field in the history the same way
+// the complaint list filters on it.
+func customFieldHistoryField(key string) string {
+	return "cf." + key
+}
+
+func formatCustomFieldValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// recordHistory stores the changes made to a complaint as part of tx.
+func recordHistory(tx *gorm.DB, complaintID uint, changes []tables.ComplaintHistory, userID uint, batchID string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	for i := range changes {
+		changes[i].ComplaintID = complaintID
+		changes[i].ChangedByID = userID
+		changes[i].BatchID = batchID
+	}
+	return tx.Create(&changes).Error
+}
+
+func (h *Handlers) GetComplaintHistory(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var complaint tables.Complaints
+	if err := h.db.Select("id").First(&complaint, complaintID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get complaint",
+			"msg":   err.Error(),
+		})
+	}
+
+	var history []tables.ComplaintHistory
+	result := h.db.Preload("ChangedBy", withDeleted).
+		Where("complaint_id = ?", complaintID).
+		Order("created_at DESC, id DESC").
+		Find(&history)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get complaint history",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(history)
+}
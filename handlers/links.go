@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errLinkFromMerge rejects removing the duplicate-of link of a merge that
+// hasn't been undone.
+var errLinkFromMerge = errors.New("link belongs to a merge")
+
+// inverseLinkTypes names each link type as seen from its target.
+var inverseLinkTypes = map[string]string{
+	tables.LinkRelatesTo:   tables.LinkRelatesTo,
+	tables.LinkDuplicateOf: "duplicated-by",
+	tables.LinkBlocks:      "blocked-by",
+	tables.LinkCausedBy:    "causes",
+}
+
+type LinkBody struct {
+	Target uint   `json:"target"`
+	Type   string `json:"type"`
+}
+
+type LinkedComplaint struct {
+	LinkID      uint          `json:"linkid"`
+	Type        string        `json:"type"`
+	ComplaintID uint          `json:"complaintid"`
+	Reference   string        `json:"reference"`
+	Description string        `json:"description"`
+	Status      tables.Status `json:"status"`
+}
+
+// linkComplaints links source to target unless an equivalent link already
+// exists, and reports whether it created the link. relates-to works both
+// ways, so a link in either direction counts.
+func linkComplaints(tx *gorm.DB, sourceID, targetID uint, linkType string, userID uint) (tables.ComplaintLinks, bool, error) {
+	var existing tables.ComplaintLinks
+	query := tx.Where("source_id = ? AND target_id = ? AND type = ?", sourceID, targetID, linkType)
+	if linkType == tables.LinkRelatesTo {
+		query = query.Or("source_id = ? AND target_id = ? AND type = ?", targetID, sourceID, linkType)
+	}
+	result := query.Limit(1).Find(&existing)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return existing, false, result.Error
+	}
+
+	link := tables.ComplaintLinks{
+		SourceID:    sourceID,
+		TargetID:    targetID,
+		Type:        linkType,
+		CreatedByID: userID,
+	}
+	result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&link)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return link, result.RowsAffected > 0, result.Error
+	}
+	// A concurrent request created the same link first.
+	err := tx.Where("source_id = ? AND target_id = ? AND type = ?", sourceID, targetID, linkType).First(&existing).Error
+	return existing, false, err
+}
+
+// recordLinkChange records a link being added or removed in the history of
+// both complaints, as seen from each, and touches them.
+func recordLinkChange(tx *gorm.DB, link tables.ComplaintLinks, added bool, userID uint) error {
+	var complaints []tables.Complaints
+	if err := tx.Where("id IN ?", []uint{link.SourceID, link.TargetID}).Find(&complaints).Error; err != nil {
+		return err
+	}
+	byID := make(map[uint]tables.Complaints, len(complaints))
+	for _, complaint := range complaints {
+		byID[complaint.ID] = complaint
+	}
+
+	for _, complaint := range complaints {
+		linkType, other := link.Type, byID[link.TargetID]
+		if complaint.ID == link.TargetID {
+			linkType, other = inverseLinkTypes[link.Type], byID[link.SourceID]
+		}
+		change := tables.ComplaintHistory{Field: "link"}
+		if added {
+			change.NewValue = linkType + " " + other.Reference
+		} else {
+			change.OldValue = linkType + " " + other.Reference
+		}
+		if err := recordHistory(tx, complaint.ID, []tables.ComplaintHistory{change}, userID, ""); err != nil {
+			return err
+		}
+		if err := complaintTouched(tx, complaint, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkedComplaints returns the complaints linked to a complaint in either
+// direction, with the link type as seen from that complaint. Links to
+// deleted complaints are left out.
+func linkedComplaints(db *gorm.DB, complaintID uint) ([]LinkedComplaint, error) {
+	var links []tables.ComplaintLinks
+	if err := db.Where("source_id = ? OR target_id = ?", complaintID, complaintID).
+		Order("created_at").Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(links))
+	for _, link := range links {
+		if link.SourceID == complaintID {
+			ids = append(ids, link.TargetID)
+		} else {
+			ids = append(ids, link.SourceID)
+		}
+	}
+	var complaints []tables.Complaints
+	if len(ids) > 0 {
+		if err := db.Select("id", "reference", "description", "status").Where("id IN ?", ids).Find(&complaints).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[uint]tables.Complaints, len(complaints))
+	for _, complaint := range complaints {
+		byID[complaint.ID] = complaint
+	}
+
+	linked := []LinkedComplaint{}
+	for i, link := range links {
+		other, ok := byID[ids[i]]
+		if !ok {
+			continue
+		}
+		linkType := link.Type
+		if link.SourceID != complaintID {
+			linkType = inverseLinkTypes[link.Type]
+		}
+		linked = append(linked, LinkedComplaint{
+			LinkID:      link.ID,
+			Type:        linkType,
+			ComplaintID: other.ID,
+			Reference:   other.Reference,
+			Description: other.Description,
+			Status:      other.Status,
+		})
+	}
+	return linked, nil
+}
+
+func (h *Handlers) AddComplaintLink(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var body LinkBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if _, ok := inverseLinkTypes[body.Type]; !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid link type. Must be relates-to, duplicate-of, blocks or caused-by.",
+		})
+	}
+	if body.Target == 0 || body.Target == complaintID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Target must be another complaint",
+		})
+	}
+
+	var count int64
+	if err := h.db.Model(&tables.Complaints{}).Where("id IN ?", []uint{complaintID, body.Target}).Count(&count).Error; err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaints",
+			"msg":   err.Error(),
+		})
+	}
+	if count != 2 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Complaint not found",
+		})
+	}
+
+	userID, _ := currentUserID(c)
+	var link tables.ComplaintLinks
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var created bool
+		var err error
+		link, created, err = linkComplaints(tx, complaintID, body.Target, body.Type, userID)
+		if err != nil || !created {
+			return err
+		}
+		return recordLinkChange(tx, link, true, userID)
+	})
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to link complaints",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Complaints linked successfully",
+		"linkid":  link.ID,
+	})
+}
+
+func (h *Handlers) DeleteComplaintLink(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+	linkID, err := strconv.ParseUint(c.Params("linkId"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid link ID format",
+		})
+	}
+
+	userID, _ := currentUserID(c)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var link tables.ComplaintLinks
+		if err := tx.Where("id = ? AND (source_id = ? OR target_id = ?)", linkID, complaintID, complaintID).
+			First(&link).Error; err != nil {
+			return err
+		}
+		var merges int64
+		if err := tx.Model(&tables.ComplaintMerges{}).
+			Where("link_id = ? AND reverted_at IS NULL", link.ID).Count(&merges).Error; err != nil {
+			return err
+		}
+		if merges > 0 {
+			return errLinkFromMerge
+		}
+		if err := tx.Delete(&link).Error; err != nil {
+			return err
+		}
+		return recordLinkChange(tx, link, false, userID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Link not found",
+		})
+	}
+	if errors.Is(err, errLinkFromMerge) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The link was created by a merge, unmerge the complaint instead",
+		})
+	}
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove link",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Link removed successfully",
+		"linkid":  linkID,
+	})
+}
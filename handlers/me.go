@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/notify"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"github.com/pedersandvoll/Practice-Exam-BE/utils"
+	"gorm.io/gorm"
+)
+
+// emailVerificationTTL is how long an email change can be confirmed.
+const emailVerificationTTL = 24 * time.Hour
+
+// UserResponse is the public representation of a user. It never includes
+// the password hash or verification tokens.
+type UserResponse struct {
+	ID           uint      `json:"id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	PendingEmail string    `json:"pendingEmail,omitempty"`
+	Verified     bool      `json:"emailVerified"`
+	Role         string    `json:"role"`
+	SSO          bool      `json:"sso"`
+	Active       bool      `json:"active"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func newUserResponse(user tables.Users) UserResponse {
+	return UserResponse{
+		ID:           user.ID,
+		Name:         user.Name,
+		Email:        user.Email,
+		PendingEmail: user.PendingEmail,
+		Verified:     user.EmailVerified,
+		Role:         user.Role,
+		SSO:          user.OIDCSubject != nil,
+		Active:       user.Active,
+		CreatedAt:    user.CreatedAt,
+	}
+}
+
+// currentUser loads the authenticated caller. When it returns a nil user the
+// error response has already been written and should be returned as-is.
+func (h *Handlers) currentUser(c *fiber.Ctx) (*tables.Users, error) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	var user tables.Users
+	result := h.db.First(&user, userID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "User no longer exists",
+			})
+		}
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve user",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return &user, nil
+}
+
+func (h *Handlers) GetMe(c *fiber.Ctx) error {
+	user, err := h.currentUser(c)
+	if user == nil {
+		return err
+	}
+
+	return c.JSON(newUserResponse(*user))
+}
+
+type MeBody struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (h *Handlers) UpdateMe(c *fiber.Ctx) error {
+	var body MeBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if body.Name == "" && body.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name or email is required",
+		})
+	}
+
+	user, err := h.currentUser(c)
+	if user == nil {
+		return err
+	}
+
+	if body.Name != "" {
+		user.Name = body.Name
+	}
+
+	// Email changes only take effect once the new address is verified.
+	// Sending the current address again verifies it if it isn't yet.
+	var verificationToken string
+	body.Email = strings.TrimSpace(body.Email)
+	if body.Email != "" && (body.Email != user.Email || !user.EmailVerified) {
+		if user.OIDCSubject != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Email is managed by the identity provider",
+			})
+		}
+
+		var count int64
+		if err := h.db.Model(&tables.Users{}).Where("email = ? AND id <> ?", body.Email, user.ID).Count(&count).Error; err != nil {
+			fmt.Println("Database error:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check email",
+				"msg":   err.Error(),
+			})
+		}
+		if count > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Email already exists",
+			})
+		}
+
+		token, hash, err := utils.GenerateToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate verification token",
+			})
+		}
+		expires := time.Now().Add(emailVerificationTTL)
+		user.PendingEmail = body.Email
+		user.EmailVerificationHash = hash
+		user.EmailVerificationExpires = &expires
+		verificationToken = token
+	}
+
+	result := h.db.Save(user)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update user",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	if verificationToken != "" {
+		h.sendEmailVerification(*user, verificationToken)
+	}
+
+	return c.JSON(newUserResponse(*user))
+}
+
+// sendEmailVerification emails the link that confirms a pending email
+// change to the new address.
+func (h *Handlers) sendEmailVerification(user tables.Users, token string) {
+	subject, body, err := notify.Render("email.verify", map[string]interface{}{
+		"Recipient": user,
+		"URL":       h.config.AppURL + "/verify-email?token=" + token,
+	})
+	if err == nil {
+		err = h.mailer.Send(user.PendingEmail, subject, body)
+	}
+	if err != nil {
+		fmt.Println("Failed to send email verification:", err)
+	}
+}
+
+func (h *Handlers) VerifyEmail(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Token is required",
+		})
+	}
+
+	var user tables.Users
+	result := h.db.Where("email_verification_hash = ?", utils.HashToken(token)).First(&user)
+	if result.Error != nil || user.EmailVerificationExpires == nil || time.Now().After(*user.EmailVerificationExpires) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired token",
+		})
+	}
+
+	user.Email = user.PendingEmail
+	user.EmailVerified = true
+	user.Role = h.roleForEmail(user.Email, user.EmailVerified, user.Role)
+	user.PendingEmail = ""
+	user.EmailVerificationHash = ""
+	user.EmailVerificationExpires = nil
+
+	result = h.db.Save(&user)
+	if result.Error != nil {
+		if strings.Contains(result.Error.Error(), "duplicate key value") {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Email already exists",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update user",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Email verified successfully",
+		"email":   user.Email,
+	})
+}
+
+type PasswordBody struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func (h *Handlers) ChangePassword(c *fiber.Ctx) error {
+	var body PasswordBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if body.CurrentPassword == "" || body.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Current and new password are required",
+		})
+	}
+
+	user, err := h.currentUser(c)
+	if user == nil {
+		return err
+	}
+
+	if user.OIDCSubject != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password is managed by the identity provider",
+		})
+	}
+
+	if !utils.VerifyPassword(body.CurrentPassword, user.Password) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Current password is wrong",
+		})
+	}
+
+	hashedPassword, err := utils.HashPassword(body.NewPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+
+	result := h.db.Model(user).Update("password", hashedPassword)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update password",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Password changed successfully",
+	})
+}
+
+func (h *Handlers) GetNotificationPreferences(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	prefs := tables.NotificationPreferences{UserID: userID, EmailMode: tables.EmailImmediate}
+	result := h.db.Limit(1).Find(&prefs, "user_id = ?", userID)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get notification preferences",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(prefs)
+}
+
+type NotificationPreferencesBody struct {
+	EmailMode string `json:"emailMode"`
+}
+
+func (h *Handlers) UpdateNotificationPreferences(c *fiber.Ctx) error {
+	var body NotificationPreferencesBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if body.EmailMode != tables.EmailImmediate && body.EmailMode != tables.EmailDigest && body.EmailMode != tables.EmailOff {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email mode. Must be immediate, digest or off.",
+		})
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	prefs := tables.NotificationPreferences{UserID: userID, EmailMode: body.EmailMode}
+	result := h.db.Save(&prefs)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update notification preferences",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(prefs)
+}
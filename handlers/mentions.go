@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"github.com/pedersandvoll/Practice-Exam-BE/utils"
+	"gorm.io/gorm"
+)
+
+type MentionResponse struct {
+	UserID uint   `json:"userid"`
+	Name   string `json:"name"`
+}
+
+// resolveMentions looks up the active users mentioned in text. A handle
+// matches the local part of a user's email, or their name with spaces
+// removed or replaced by dots ("@john.doe", "@johndoe"). Handles matching
+// more than one user are ambiguous and ignored. Customers can't be mentioned
+// in internal notes.
+func (h *Handlers) resolveMentions(text, visibility string) ([]tables.Users, error) {
+	var users []tables.Users
+	seen := map[uint]bool{}
+
+	for _, handle := range utils.ParseMentions(text) {
+		var matches []tables.Users
+		query := h.db.Where("active = ?", true)
+		if visibility != tables.VisibilityPublic {
+			query = query.Where("role <> ?", tables.RoleCustomer)
+		}
+		result := query.
+			Where("LOWER(SPLIT_PART(email, '@', 1)) = ? OR LOWER(REPLACE(name, ' ', '')) = ? OR LOWER(REPLACE(name, ' ', '.')) = ?",
+				handle, handle, handle).
+			Limit(2).
+			Find(&matches)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		if len(matches) == 1 && !seen[matches[0].ID] {
+			seen[matches[0].ID] = true
+			users = append(users, matches[0])
+		}
+	}
+
+	return users, nil
+}
+
+func saveMentions(tx *gorm.DB, commentID uint, users []tables.Users) error {
+	for _, user := range users {
+		if err := tx.Create(&tables.CommentMentions{CommentID: commentID, UserID: user.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newMentionResponses(users []tables.Users) []MentionResponse {
+	mentions := make([]MentionResponse, 0, len(users))
+	for _, user := range users {
+		mentions = append(mentions, MentionResponse{UserID: user.ID, Name: user.Name})
+	}
+	return mentions
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errMergeRejected  = errors.New("merge rejected")
+	errMergeForbidden = errors.New("merge forbidden")
+)
+
+type MergeBody struct {
+	Duplicates []uint `json:"duplicates"`
+}
+
+// mergeHistory returns the history row for a change of MergedIntoID.
+func mergeHistory(before, after *uint) tables.ComplaintHistory {
+	return tables.ComplaintHistory{
+		Field:    "merged_into",
+		OldValue: formatOptionalID(before),
+		NewValue: formatOptionalID(after),
+	}
+}
+
+// canMerge reports whether the caller may merge a duplicate away or undo
+// that. Merging closes the duplicate, so like deleting it only its creator
+// or an admin may, and customers never can.
+func canMerge(c *fiber.Ctx, duplicate tables.Complaints, userID uint) bool {
+	if c.Locals("role") == tables.RoleCustomer {
+		return false
+	}
+	return isAdmin(c) || duplicate.CreatedByID == userID
+}
+
+// mergeComplaint moves everything from a duplicate to the primary complaint,
+// closes the duplicate and links it to the primary as a duplicate-of,
+// recording how to undo it.
+func mergeComplaint(tx *gorm.DB, primary, duplicate tables.Complaints, userID uint) error {
+	var commentIDs []uint
+	if err := tx.Model(&tables.Comments{}).Where("complaint_id = ?", duplicate.ID).Pluck("id", &commentIDs).Error; err != nil {
+		return err
+	}
+	var watcherIDs []uint
+	if err := tx.Model(&tables.ComplaintWatchers{}).
+		Where("complaint_id = ? AND user_id NOT IN (?)", duplicate.ID,
+			tx.Model(&tables.ComplaintWatchers{}).Select("user_id").Where("complaint_id = ?", primary.ID)).
+		Pluck("user_id", &watcherIDs).Error; err != nil {
+		return err
+	}
+
+	merge := tables.ComplaintMerges{
+		PrimaryID:      primary.ID,
+		DuplicateID:    duplicate.ID,
+		PreviousStatus: duplicate.Status,
+		MergedByID:     userID,
+	}
+	for _, id := range commentIDs {
+		merge.MovedComments = append(merge.MovedComments, tables.ComplaintMergeComments{CommentID: id})
+	}
+	for _, id := range watcherIDs {
+		merge.AddedWatchers = append(merge.AddedWatchers, tables.ComplaintMergeWatchers{UserID: id})
+	}
+	link, created, err := linkComplaints(tx, duplicate.ID, primary.ID, tables.LinkDuplicateOf, userID)
+	if err != nil {
+		return err
+	}
+	if created {
+		merge.LinkID = &link.ID
+	}
+	if err := tx.Create(&merge).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&tables.Comments{}).Where("complaint_id = ?", duplicate.ID).
+		Update("complaint_id", primary.ID).Error; err != nil {
+		return err
+	}
+	for _, id := range watcherIDs {
+		if err := follow(tx, primary.ID, &id); err != nil {
+			return err
+		}
+	}
+
+	before := duplicate
+	duplicate.Status = tables.Solved
+	duplicate.MergedIntoID = &primary.ID
+	duplicate.Version++
+	if err := saveVersioned(tx, &duplicate, before.Version); err != nil {
+		return err
+	}
+	changes := append(complaintChanges(before, duplicate), mergeHistory(before.MergedIntoID, duplicate.MergedIntoID))
+	if err := recordHistory(tx, duplicate.ID, changes, userID, ""); err != nil {
+		return err
+	}
+	if err := recordComplaintEvent(tx, events.ComplaintUpdated, duplicate, userID, 0); err != nil {
+		return err
+	}
+	return recordComplaintEvent(tx, events.ComplaintMerged, primary, userID, 0)
+}
+
+// MergeComplaints merges the duplicates in the request body into the
+// complaint in the URL. The duplicates' comments move to the primary
+// complaint, their watchers start watching it, and they are closed with
+// MergedIntoID pointing at it. The caller must be allowed to merge every
+// duplicate, see canMerge.
+func (h *Handlers) MergeComplaints(c *fiber.Ctx) error {
+	primaryID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var body MergeBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if len(body.Duplicates) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one duplicate is required",
+		})
+	}
+	seen := map[uint]bool{}
+	for _, id := range body.Duplicates {
+		if id == primaryID || seen[id] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Duplicates must be distinct and different from the primary complaint",
+			})
+		}
+		seen[id] = true
+	}
+
+	// reject aborts the transaction with a client error.
+	var rejectStatus int
+	var rejectMsg string
+	reject := func(status int, msg string) error {
+		rejectStatus, rejectMsg = status, msg
+		return errMergeRejected
+	}
+
+	userID, _ := currentUserID(c)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var primary tables.Complaints
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&primary, primaryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return reject(fiber.StatusNotFound, "Complaint not found")
+			}
+			return err
+		}
+		if primary.MergedIntoID != nil {
+			return reject(fiber.StatusConflict, "The primary complaint has itself been merged into another complaint")
+		}
+
+		var duplicates []tables.Complaints
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", body.Duplicates).Order("id").Find(&duplicates).Error; err != nil {
+			return err
+		}
+		if len(duplicates) != len(body.Duplicates) {
+			return reject(fiber.StatusNotFound, "Some duplicates were not found")
+		}
+
+		for _, duplicate := range duplicates {
+			if !canMerge(c, duplicate, userID) {
+				return reject(fiber.StatusForbidden, fmt.Sprintf("Only the creator of complaint %d or an admin can merge it", duplicate.ID))
+			}
+			if duplicate.MergedIntoID != nil {
+				return reject(fiber.StatusConflict, fmt.Sprintf("Complaint %d has already been merged", duplicate.ID))
+			}
+			if err := mergeComplaint(tx, primary, duplicate, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if errors.Is(err, errMergeRejected) {
+		return c.Status(rejectStatus).JSON(fiber.Map{
+			"error": rejectMsg,
+		})
+	}
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to merge complaints",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaints merged successfully",
+		"complaintid": primaryID,
+		"merged":      body.Duplicates,
+	})
+}
+
+// UnmergeComplaint reverts the merge of the duplicate complaint in the URL.
+// The comments that were moved go back, along with any replies written to
+// them since, the watchers the primary gained from it are removed, the
+// duplicate gets its old status back and the duplicate-of link the merge
+// created is removed.
+// Besides those allowed to merge it, whoever merged it can undo it.
+func (h *Handlers) UnmergeComplaint(c *fiber.Ctx) error {
+	duplicateID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var merge tables.ComplaintMerges
+	result := h.db.Preload("MovedComments").
+		Preload("AddedWatchers").
+		Where("duplicate_id = ? AND reverted_at IS NULL", duplicateID).
+		First(&merge)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint has not been merged",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load merge",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	var primary tables.Complaints
+	if err := h.db.First(&primary, merge.PrimaryID).Error; err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The complaint it was merged into has been deleted",
+		})
+	}
+
+	userID, _ := currentUserID(c)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var duplicate tables.Complaints
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&duplicate, duplicateID).Error; err != nil {
+			return err
+		}
+		if merge.MergedByID != userID && !canMerge(c, duplicate, userID) {
+			return errMergeForbidden
+		}
+
+		reverted := tx.Model(&merge).Where("reverted_at IS NULL").Updates(map[string]interface{}{
+			"reverted_at":    time.Now(),
+			"reverted_by_id": userID,
+		})
+		if reverted.Error != nil {
+			return reverted.Error
+		}
+		if reverted.RowsAffected == 0 {
+			return errMergeRejected
+		}
+
+		if len(merge.MovedComments) > 0 {
+			commentIDs := make([]uint, 0, len(merge.MovedComments))
+			for _, moved := range merge.MovedComments {
+				commentIDs = append(commentIDs, moved.CommentID)
+			}
+			if err := tx.Exec(`WITH RECURSIVE thread AS (
+					SELECT id FROM comments WHERE id IN ?
+					UNION SELECT comments.id FROM comments JOIN thread ON comments.parent_id = thread.id
+				)
+				UPDATE comments SET complaint_id = ?
+				WHERE id IN (SELECT id FROM thread) AND complaint_id = ?`,
+				commentIDs, duplicate.ID, merge.PrimaryID).Error; err != nil {
+				return err
+			}
+		}
+		if len(merge.AddedWatchers) > 0 {
+			userIDs := make([]uint, 0, len(merge.AddedWatchers))
+			for _, added := range merge.AddedWatchers {
+				userIDs = append(userIDs, added.UserID)
+			}
+			if err := tx.Where("complaint_id = ? AND user_id IN ?", merge.PrimaryID, userIDs).
+				Delete(&tables.ComplaintWatchers{}).Error; err != nil {
+				return err
+			}
+		}
+
+		before := duplicate
+		duplicate.Status = merge.PreviousStatus
+		duplicate.MergedIntoID = nil
+		duplicate.Version++
+		if err := saveVersioned(tx, &duplicate, before.Version); err != nil {
+			return err
+		}
+		changes := append(complaintChanges(before, duplicate), mergeHistory(before.MergedIntoID, nil))
+		if err := recordHistory(tx, duplicate.ID, changes, userID, ""); err != nil {
+			return err
+		}
+		if merge.LinkID != nil {
+			if err := tx.Delete(&tables.ComplaintLinks{}, *merge.LinkID).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := recordComplaintEvent(tx, events.ComplaintUpdated, duplicate, userID, 0); err != nil {
+			return err
+		}
+		return recordComplaintEvent(tx, events.ComplaintUnmerged, primary, userID, 0)
+	})
+
+	if errors.Is(err, errMergeForbidden) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the creator of the complaint, whoever merged it or an admin can unmerge it",
+		})
+	}
+	if errors.Is(err, errMergeRejected) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Complaint has already been unmerged",
+		})
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unmerge complaint",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaint unmerged successfully",
+		"complaintid": duplicateID,
+	})
+}
+
+// GetComplaintMerges lists the merges a complaint took part in, either as the
+// primary or as a duplicate, including reverted ones.
+func (h *Handlers) GetComplaintMerges(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var merges []tables.ComplaintMerges
+	result := h.db.Preload("MergedBy", withDeleted).
+		Preload("MovedComments").
+		Where("primary_id = ? OR duplicate_id = ?", complaintID, complaintID).
+		Order("created_at DESC").
+		Find(&merges)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get merges",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(merges)
+}
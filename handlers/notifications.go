@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+)
+
+func (h *Handlers) GetNotifications(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	query := h.db.Preload("Actor", withDeleted).Where("user_id = ?", userID)
+	if c.QueryBool("unread") {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []tables.Notifications
+	result := query.Order("created_at DESC").Limit(limit).Find(&notifications)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get notifications",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(notifications)
+}
+
+func (h *Handlers) GetUnreadNotificationCount(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	var count int64
+	result := h.db.Model(&tables.Notifications{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count notifications",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{"unread": count})
+}
+
+func (h *Handlers) MarkNotificationRead(c *fiber.Ctx) error {
+	return h.setNotificationRead(c, true)
+}
+
+func (h *Handlers) MarkNotificationUnread(c *fiber.Ctx) error {
+	return h.setNotificationRead(c, false)
+}
+
+func (h *Handlers) setNotificationRead(c *fiber.Ctx, read bool) error {
+	notificationID := c.Params("id")
+	if notificationID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ID is required in the URL",
+		})
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	var readAt *time.Time
+	if read {
+		now := time.Now()
+		readAt = &now
+	}
+
+	result := h.db.Model(&tables.Notifications{}).
+		Where("id = ? AND user_id = ?", notificationID, userID).
+		Update("read_at", readAt)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update notification",
+			"msg":   result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Notification not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":        "Notification updated successfully",
+		"notificationid": notificationID,
+	})
+}
+
+func (h *Handlers) MarkAllNotificationsRead(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	result := h.db.Model(&tables.Notifications{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update notifications",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Notifications marked as read",
+		"updated": result.RowsAffected,
+	})
+}
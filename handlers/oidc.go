@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/auth"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+func (h *Handlers) OIDCLogin(c *fiber.Ctx) error {
+	if h.oidc == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Single sign-on is not configured",
+		})
+	}
+
+	authURL, err := h.oidc.AuthCodeURL()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start single sign-on",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+func (h *Handlers) OIDCCallback(c *fiber.Ctx) error {
+	if h.oidc == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Single sign-on is not configured",
+		})
+	}
+
+	if idpError := c.Query("error"); idpError != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Single sign-on was rejected by the identity provider",
+			"msg":   idpError,
+		})
+	}
+
+	code := c.Query("code")
+	state := c.Query("state")
+	if code == "" || state == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code and state are required",
+		})
+	}
+
+	claims, err := h.oidc.Exchange(c.Context(), state, code)
+	if err != nil {
+		fmt.Println("OIDC error:", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Single sign-on failed",
+			"msg":   err.Error(),
+		})
+	}
+
+	user, err := h.provisionOIDCUser(claims)
+	if errors.Is(err, errOIDCEmailUnverified) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "The identity provider has not verified your email address",
+		})
+	}
+	if err != nil && strings.Contains(err.Error(), "duplicate key value") {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Email already belongs to another account",
+		})
+	}
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to provision user",
+			"msg":   err.Error(),
+		})
+	}
+
+	if !user.Active {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "User is deactivated",
+		})
+	}
+
+	t, err := h.issueToken(user)
+	if err != nil {
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	if h.config.OIDCLoginURL != "" {
+		return c.Redirect(h.config.OIDCLoginURL+"#token="+url.QueryEscape(t), fiber.StatusFound)
+	}
+
+	return c.JSON(fiber.Map{"token": t})
+}
+
+// errOIDCEmailUnverified rejects a first single sign-on whose email the
+// identity provider hasn't verified, since accounts are known by email.
+var errOIDCEmailUnverified = errors.New("email not verified by identity provider")
+
+// provisionOIDCUser finds the local user for an identity provider subject,
+// linking an existing account by verified email or creating a new one, and
+// syncs the role from the user's IdP groups on every login. Only a verified
+// email is ever copied onto the user.
+func (h *Handlers) provisionOIDCUser(claims *auth.IDTokenClaims) (tables.Users, error) {
+	var user tables.Users
+	result := h.db.Where("oidc_subject = ?", claims.Subject).First(&user)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) && claims.Email != "" && claims.EmailVerified {
+		result = h.db.Where("email = ? AND oidc_subject IS NULL", claims.Email).First(&user)
+	}
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return user, result.Error
+	}
+	if user.ID == 0 && !claims.EmailVerified {
+		return user, errOIDCEmailUnverified
+	}
+
+	subject := claims.Subject
+	user.OIDCSubject = &subject
+	if claims.Name != "" {
+		user.Name = claims.Name
+	}
+	if claims.Email != "" && claims.EmailVerified {
+		user.Email = claims.Email
+		user.EmailVerified = true
+	}
+	user.Role = h.roleForEmail(user.Email, user.EmailVerified, h.roleForGroups(claims.Groups))
+
+	return user, h.db.Save(&user).Error
+}
+
+func (h *Handlers) roleForGroups(groups []string) string {
+	role := h.config.OIDCDefaultRole
+	for _, group := range groups {
+		mapped, ok := h.config.OIDCGroupRoles[group]
+		if !ok {
+			continue
+		}
+		if mapped == tables.RoleAdmin {
+			return tables.RoleAdmin
+		}
+		role = mapped
+	}
+	return role
+}
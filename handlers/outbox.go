@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+// recordComplaintEvent writes a complaint event to the outbox as part of tx.
+// Side effects such as live updates are triggered by the outbox dispatcher
+// once the transaction has committed.
+func recordComplaintEvent(tx *gorm.DB, eventType string, complaint tables.Complaints, actorID, commentID uint) error {
+	return events.Record(tx, events.Event{
+		Type:        eventType,
+		ComplaintID: complaint.ID,
+		CustomerID:  complaint.CustomerID,
+		CategoryID:  complaint.CategoryId,
+		AssigneeID:  complaint.AssigneeID,
+		CommentID:   commentID,
+		ActorID:     actorID,
+	})
+}
+
+// recordCommentEvent writes a comment event including the users mentioned
+// in the comment.
+func recordCommentEvent(tx *gorm.DB, eventType string, complaint tables.Complaints, comment tables.Comments, actorID uint) error {
+	mentions := make([]uint, 0, len(comment.Mentions))
+	for _, user := range comment.Mentions {
+		mentions = append(mentions, user.ID)
+	}
+
+	return events.Record(tx, events.Event{
+		Type:        eventType,
+		ComplaintID: complaint.ID,
+		CustomerID:  complaint.CustomerID,
+		CategoryID:  complaint.CategoryId,
+		AssigneeID:  complaint.AssigneeID,
+		CommentID:   comment.ID,
+		Visibility:  comment.Visibility,
+		Mentions:    mentions,
+		ActorID:     actorID,
+	})
+}
+
+// complaintTouched bumps the version of a complaint whose tags or links
+// changed, since they are part of it, and tells its watchers.
+func complaintTouched(tx *gorm.DB, complaint tables.Complaints, userID uint) error {
+	if err := tx.Model(&complaint).UpdateColumn("version", gorm.Expr("version + 1")).Error; err != nil {
+		return err
+	}
+	return recordComplaintEvent(tx, events.ComplaintUpdated, complaint, userID, 0)
+}
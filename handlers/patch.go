@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+func validPriority(p tables.Priority) bool {
+	return p == tables.High || p == tables.Medium || p == tables.Low
+}
+
+func validStatus(s tables.Status) bool {
+	return s == tables.New || s == tables.UnderTreatment || s == tables.Solved
+}
+
+func isNull(raw json.RawMessage) bool {
+	return string(raw) == "null"
+}
+
+// complaintPatch is a validated JSON Merge Patch (RFC 7396) for a complaint.
+// Nil fields were not in the patch and are left alone.
+type complaintPatch struct {
+	Description   *string
+	Priority      *tables.Priority
+	Status        *tables.Status
+	CategoryID    *uint
+	ComplaintDate *time.Time
+	// SetAssignee is true when the patch has an assignee; AssigneeID is then
+	// the new assignee, or nil to unassign.
+	SetAssignee bool
+	AssigneeID  *uint
+	// CustomFields is itself a merge patch: null removes a value.
+	CustomFields map[string]json.RawMessage
+}
+
+// patchError is a patch that doesn't validate, as opposed to a database error
+// while validating it.
+type patchError struct {
+	msg string
+}
+
+func (e *patchError) Error() string {
+	return e.msg
+}
+
+func invalidPatch(format string, args ...interface{}) error {
+	return &patchError{msg: fmt.Sprintf(format, args...)}
+}
+
+// patchFailed answers a patch parseComplaintPatch couldn't validate.
+func patchFailed(c *fiber.Ctx, err error) error {
+	var invalid *patchError
+	if errors.As(err, &invalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	fmt.Println("Database error:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to validate changes",
+		"msg":   err.Error(),
+	})
+}
+
+// parseComplaintPatch validates the fields present in a patch. A field set to
+// null is only allowed where it makes sense, which is just the assignee.
+// Validation errors are *patchError.
+func (h *Handlers) parseComplaintPatch(fields map[string]json.RawMessage) (complaintPatch, error) {
+	var patch complaintPatch
+	for field, raw := range fields {
+		switch field {
+		case "description":
+			var description string
+			if err := json.Unmarshal(raw, &description); err != nil || description == "" {
+				return patch, invalidPatch("Description must be a non-empty string")
+			}
+			patch.Description = &description
+		case "priority":
+			var priority tables.Priority
+			if err := json.Unmarshal(raw, &priority); err != nil || isNull(raw) || !validPriority(priority) {
+				return patch, invalidPatch("Invalid priority value. Must be High, Medium, or Low.")
+			}
+			patch.Priority = &priority
+		case "status":
+			var status tables.Status
+			if err := json.Unmarshal(raw, &status); err != nil || isNull(raw) || !validStatus(status) {
+				return patch, invalidPatch("Invalid status value. Must be New, UnderTreatment, or Solved.")
+			}
+			patch.Status = &status
+		case "category":
+			var categoryID uint
+			if err := json.Unmarshal(raw, &categoryID); err != nil || categoryID == 0 {
+				return patch, invalidPatch("Category must be a category ID")
+			}
+			var count int64
+			if err := h.db.Model(&tables.Categories{}).Where("id = ?", categoryID).Count(&count).Error; err != nil {
+				return patch, err
+			}
+			if count == 0 {
+				return patch, invalidPatch("Category does not exist")
+			}
+			patch.CategoryID = &categoryID
+		case "date":
+			var date time.Time
+			if err := json.Unmarshal(raw, &date); err != nil || isNull(raw) {
+				return patch, invalidPatch("Date must be an RFC 3339 timestamp")
+			}
+			patch.ComplaintDate = &date
+		case "assignee":
+			patch.SetAssignee = true
+			if isNull(raw) {
+				continue
+			}
+			var assigneeID uint
+			if err := json.Unmarshal(raw, &assigneeID); err != nil || !h.validAssignee(&assigneeID) {
+				return patch, invalidPatch("Assignee does not exist or is deactivated")
+			}
+			patch.AssigneeID = &assigneeID
+		case "customFields":
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &values); err != nil || isNull(raw) {
+				return patch, invalidPatch("Custom fields must be an object")
+			}
+			patch.CustomFields = values
+		default:
+			return patch, invalidPatch("Unknown field %q", field)
+		}
+	}
+	return patch, nil
+}
+
+// applyCustomFields validates the complaint's custom fields against the
+// category it will have after the patch and updates them. Stored values are
+// only checked when the patch changes the custom fields or the category. It
+// must be called before applyTo.
+func (p complaintPatch) applyCustomFields(db *gorm.DB, complaint *tables.Complaints) error {
+	categoryID := complaint.CategoryId
+	if p.CategoryID != nil {
+		categoryID = *p.CategoryID
+	}
+	if p.CustomFields == nil && categoryID == complaint.CategoryId {
+		return nil
+	}
+	values, err := resolveCustomFields(db, categoryID, complaint.CustomFields, p.CustomFields)
+	if err != nil {
+		return err
+	}
+	complaint.CustomFields = values
+	return nil
+}
+
+// applyTo changes the complaint and reports whether it was assigned to
+// someone new.
+func (p complaintPatch) applyTo(complaint *tables.Complaints) bool {
+	if p.Description != nil {
+		complaint.Description = *p.Description
+	}
+	if p.Priority != nil {
+		complaint.Priority = *p.Priority
+	}
+	if p.Status != nil {
+		complaint.Status = *p.Status
+	}
+	if p.CategoryID != nil {
+		complaint.CategoryId = *p.CategoryID
+	}
+	if p.ComplaintDate != nil {
+		complaint.ComplaintDate = *p.ComplaintDate
+	}
+	assigned := false
+	if p.SetAssignee {
+		if p.AssigneeID != nil && (complaint.AssigneeID == nil || *complaint.AssigneeID != *p.AssigneeID) {
+			assigned = true
+		}
+		complaint.AssigneeID = p.AssigneeID
+		complaint.Assignee = nil
+	}
+	return assigned
+}
+
+// PatchComplaint updates only the fields sent in the request body, which is a
+// JSON Merge Patch document. `"assignee": null` unassigns the complaint. A
+// patch that doesn't change anything is accepted without bumping the version.
+func (h *Handlers) PatchComplaint(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &fields); err != nil || fields == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Request body must be a JSON object",
+		})
+	}
+
+	match, ok, err := requireIfMatch(c)
+	if !ok {
+		return err
+	}
+
+	var complaint tables.Complaints
+	result := h.db.First(&complaint, complaintID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+		})
+	}
+	if !match.matches(complaint.Version) {
+		return versionConflict(c, complaint, complaint.Version)
+	}
+	expected := complaint.Version
+
+	patch, err := h.parseComplaintPatch(fields)
+	if err != nil {
+		return patchFailed(c, err)
+	}
+	before := complaint
+	if err := patch.applyCustomFields(h.db.DB, &complaint); err != nil {
+		return customFieldsFailed(c, err)
+	}
+	assigned := patch.applyTo(&complaint)
+	changes := complaintChanges(before, complaint)
+
+	if len(changes) > 0 {
+		complaint.Version = expected + 1
+		userID, _ := currentUserID(c)
+		err = h.db.Transaction(func(tx *gorm.DB) error {
+			if err := saveVersioned(tx, &complaint, expected); err != nil {
+				return err
+			}
+			if err := recordHistory(tx, complaint.ID, changes, userID, ""); err != nil {
+				return err
+			}
+			if err := recordComplaintEvent(tx, events.ComplaintUpdated, complaint, userID, 0); err != nil {
+				return err
+			}
+			if assigned {
+				if err := follow(tx, complaint.ID, complaint.AssigneeID); err != nil {
+					return err
+				}
+				return recordComplaintEvent(tx, events.ComplaintAssigned, complaint, userID, 0)
+			}
+			return nil
+		})
+		if errors.Is(err, errVersionConflict) {
+			return h.complaintConflict(c, complaint.ID)
+		}
+		if err != nil {
+			fmt.Println("Database error:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update complaint",
+				"msg":   err.Error(),
+			})
+		}
+	}
+
+	c.Set(fiber.HeaderETag, etag(complaint.Version))
+	return c.JSON(fiber.Map{
+		"message":     "Complaint updated successfully",
+		"complaintid": complaint.ID,
+	})
+}
+
+// complaintConflict answers a lost update race with the complaint as it is
+// now.
+func (h *Handlers) complaintConflict(c *fiber.Ctx, complaintID uint) error {
+	var current tables.Complaints
+	if err := h.db.First(&current, complaintID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+			"msg":   err.Error(),
+		})
+	}
+	return versionConflict(c, current, current.Version)
+}
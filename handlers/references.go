@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+var referencePattern = regexp.MustCompile(`(?i)^CMP-\d{4}-\d{6,}$`)
+
+// nextReference takes the next reference number for a complaint created now.
+func nextReference(tx *gorm.DB) (string, error) {
+	var number int64
+	if err := tx.Raw("SELECT nextval('" + tables.ReferenceSequence + "')").Scan(&number).Error; err != nil {
+		return "", err
+	}
+	return tables.ComplaintReference(time.Now().Year(), number), nil
+}
+
+// errInvalidComplaintID is returned by complaintIDParam for a :id parameter
+// that is neither an ID nor a reference number.
+var errInvalidComplaintID = errors.New("invalid complaint ID")
+
+// complaintIDParam parses the :id route parameter of complaint routes, which
+// is either the complaint's ID or its reference number. An unknown reference
+// gives ID 0, which matches no complaint, so callers answer it with their
+// usual 404.
+func (h *Handlers) complaintIDParam(c *fiber.Ctx) (uint, error) {
+	param := c.Params("id")
+	if !referencePattern.MatchString(param) {
+		id, err := strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return 0, errInvalidComplaintID
+		}
+		return uint(id), nil
+	}
+
+	var ids []uint
+	err := h.db.Unscoped().Model(&tables.Complaints{}).
+		Where("reference = ?", strings.ToUpper(param)).
+		Limit(1).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// complaintIDFailed answers a request whose :id complaintIDParam couldn't
+// resolve: a malformed parameter is the client's fault, anything else is a
+// database error.
+func complaintIDFailed(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errInvalidComplaintID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid complaint ID format",
+		})
+	}
+	fmt.Println("Database error:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to look up complaint",
+		"msg":   err.Error(),
+	})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+type TagCount struct {
+	TagID uint   `json:"tagid"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// GetStatistics counts complaints by status, priority and tag. It takes the
+// same filters as the complaint list, so the numbers match what the list
+// shows. Every tag in the catalogue is listed, including unused ones.
+func (h *Handlers) GetStatistics(c *fiber.Ctx) error {
+	filter, err := parseComplaintFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid query parameters",
+			"msg":   err.Error(),
+		})
+	}
+	userID, _ := currentUserID(c)
+	complaints := func() *gorm.DB {
+		return filter.apply(h.db.Model(&tables.Complaints{}), userID)
+	}
+
+	var total int64
+	var byStatus, byPriority []struct {
+		Value int
+		Count int64
+	}
+	tags := []TagCount{}
+
+	err = complaints().Count(&total).Error
+	if err == nil {
+		err = complaints().Select("status AS value, COUNT(*) AS count").Group("status").Scan(&byStatus).Error
+	}
+	if err == nil {
+		err = complaints().Select("priority AS value, COUNT(*) AS count").Group("priority").Scan(&byPriority).Error
+	}
+	if err == nil {
+		err = h.db.Table("tags").
+			Select("tags.id AS tag_id, tags.name, COUNT(complaint_tags.complaint_id) AS count").
+			Joins("LEFT JOIN complaint_tags ON complaint_tags.tag_id = tags.id AND complaint_tags.complaint_id IN (?)",
+				complaints().Select("complaints.id")).
+			Group("tags.id, tags.name").
+			Order("count DESC, tags.name").
+			Scan(&tags).Error
+	}
+
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get statistics",
+			"msg":   err.Error(),
+		})
+	}
+
+	statusCounts := map[string]int64{}
+	for _, row := range byStatus {
+		statusCounts[tables.Status(row.Value).String()] = row.Count
+	}
+	priorityCounts := map[string]int64{}
+	for _, row := range byPriority {
+		priorityCounts[tables.Priority(row.Value).String()] = row.Count
+	}
+
+	return c.JSON(fiber.Map{
+		"total":      total,
+		"byStatus":   statusCounts,
+		"byPriority": priorityCounts,
+		"byTag":      tags,
+	})
+}
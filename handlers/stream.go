@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+)
+
+// streamHeartbeat keeps idle connections from being closed by proxies.
+const streamHeartbeat = 15 * time.Second
+
+type streamFilter struct {
+	customerID uint64
+	categoryID uint64
+	assigneeID uint64
+	// internal is whether the client may see events for internal notes.
+	internal bool
+}
+
+func (f streamFilter) matches(event events.Event) bool {
+	if !f.internal && event.CommentID != 0 && event.Visibility != tables.VisibilityPublic {
+		return false
+	}
+	if f.customerID != 0 && uint64(event.CustomerID) != f.customerID {
+		return false
+	}
+	if f.categoryID != 0 && uint64(event.CategoryID) != f.categoryID {
+		return false
+	}
+	if f.assigneeID != 0 && (event.AssigneeID == nil || uint64(*event.AssigneeID) != f.assigneeID) {
+		return false
+	}
+	return true
+}
+
+// StreamComplaints streams complaint events as Server-Sent Events. Clients
+// can filter on customerId, categoryId and assigneeId, and resume after a
+// reconnect with the Last-Event-ID header (or the lastEventId query
+// parameter). If the requested events are no longer available a "reset"
+// event is sent and the client should reload complaints.
+func (h *Handlers) StreamComplaints(c *fiber.Ctx) error {
+	filter := streamFilter{internal: canSeeInternal(c)}
+	var err error
+	for param, target := range map[string]*uint64{
+		"customerId": &filter.customerID,
+		"categoryId": &filter.categoryID,
+		"assigneeId": &filter.assigneeID,
+	} {
+		if value := c.Query(param); value != "" {
+			if *target, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid " + param,
+				})
+			}
+		}
+	}
+
+	lastEventID := c.Get("Last-Event-ID", c.Query("lastEventId"))
+	var lastID uint64
+	if lastEventID != "" {
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid last event ID",
+			})
+		}
+	}
+
+	replay, complete, ch, cancel := h.events.Subscribe(lastID)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		if !complete {
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, event := range replay {
+			if filter.matches(event) {
+				writeEvent(w, event)
+			}
+		}
+		if w.Flush() != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event := <-ch:
+				if !filter.matches(event) {
+					continue
+				}
+				writeEvent(w, event)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			if w.Flush() != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+func writeEvent(w *bufio.Writer, event events.Event) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
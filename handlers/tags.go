@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TagBody struct {
+	Name string `json:"name"`
+}
+
+type ComplaintTagsBody struct {
+	Tags []uint `json:"tags"`
+}
+
+// validTagName trims a tag name and checks it. Commas aren't allowed because
+// the tag filter is a comma-separated list of names.
+func validTagName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && len(name) <= 50 && !strings.Contains(name, ",")
+}
+
+func (h *Handlers) GetTags(c *fiber.Ctx) error {
+	var tags []tables.Tags
+	result := h.db.Order("name").Find(&tags)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get tags",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(tags)
+}
+
+func (h *Handlers) CreateTag(c *fiber.Ctx) error {
+	var body TagBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	name, ok := validTagName(body.Name)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Tag name is required, at most 50 characters and without commas",
+		})
+	}
+
+	tag := tables.Tags{Name: name}
+	if err := h.db.Create(&tag).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key value") {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Tag already exists",
+			})
+		}
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create tag",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Tag created successfully",
+		"tagid":   tag.ID,
+	})
+}
+
+func (h *Handlers) EditTag(c *fiber.Ctx) error {
+	tagID := c.Params("id")
+	var body TagBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	name, ok := validTagName(body.Name)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Tag name is required, at most 50 characters and without commas",
+		})
+	}
+
+	result := h.db.Model(&tables.Tags{}).Where("id = ?", tagID).Update("name", name)
+	if result.Error != nil {
+		if strings.Contains(result.Error.Error(), "duplicate key value") {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Tag already exists",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update tag",
+			"msg":   result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Tag not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Tag updated successfully",
+		"tagid":   tagID,
+	})
+}
+
+// DeleteTag removes a tag from the catalogue and from every complaint.
+func (h *Handlers) DeleteTag(c *fiber.Ctx) error {
+	tagID := c.Params("id")
+
+	var deleted int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE complaints SET version = version + 1
+			WHERE id IN (SELECT complaint_id FROM complaint_tags WHERE tag_id = ?)`, tagID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tag_id = ?", tagID).Delete(&tables.ComplaintTags{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", tagID).Delete(&tables.Tags{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete tag",
+			"msg":   err.Error(),
+		})
+	}
+	if deleted == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Tag not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Tag deleted successfully",
+		"tagid":   tagID,
+	})
+}
+
+// TagComplaint adds tags from the catalogue to a complaint. Tags the
+// complaint already has are ignored.
+func (h *Handlers) TagComplaint(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var body ComplaintTagsBody
+	if err := c.BodyParser(&body); err != nil || len(body.Tags) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one tag is required",
+		})
+	}
+
+	var complaint tables.Complaints
+	if err := h.db.Preload("Tags").First(&complaint, complaintID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+		})
+	}
+
+	var tags []tables.Tags
+	if err := h.db.Where("id IN ?", body.Tags).Find(&tags).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load tags",
+			"msg":   err.Error(),
+		})
+	}
+	found := map[uint]bool{}
+	for _, tag := range tags {
+		found[tag.ID] = true
+	}
+	for _, id := range body.Tags {
+		if !found[id] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Tag %d does not exist", id),
+			})
+		}
+	}
+
+	had := map[uint]bool{}
+	for _, tag := range complaint.Tags {
+		had[tag.ID] = true
+	}
+
+	userID, _ := currentUserID(c)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var changes []tables.ComplaintHistory
+		for _, tag := range tags {
+			if had[tag.ID] {
+				continue
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&tables.ComplaintTags{ComplaintID: complaint.ID, TagID: tag.ID}).Error; err != nil {
+				return err
+			}
+			changes = append(changes, tables.ComplaintHistory{Field: "tag", NewValue: tag.Name})
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		if err := recordHistory(tx, complaint.ID, changes, userID, ""); err != nil {
+			return err
+		}
+		return complaintTouched(tx, complaint, userID)
+	})
+
+	if err != nil {
+		fmt.Println("Database error:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to tag complaint",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaint tagged successfully",
+		"complaintid": complaint.ID,
+	})
+}
+
+func (h *Handlers) UntagComplaint(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+	tagID, err := strconv.ParseUint(c.Params("tagId"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid tag ID format",
+		})
+	}
+
+	var complaint tables.Complaints
+	if err := h.db.First(&complaint, complaintID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+		})
+	}
+
+	var tag tables.Tags
+	if err := h.db.First(&tag, tagID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Tag not found",
+		})
+	}
+
+	userID, _ := currentUserID(c)
+	var removed int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("complaint_id = ? AND tag_id = ?", complaint.ID, tag.ID).Delete(&tables.ComplaintTags{})
+		if result.Error != nil {
+			return result.Error
+		}
+		removed = result.RowsAffected
+		if removed == 0 {
+			return nil
+		}
+		if err := recordHistory(tx, complaint.ID, []tables.ComplaintHistory{{Field: "tag", OldValue: tag.Name}}, userID, ""); err != nil {
+			return err
+		}
+		return complaintTouched(tx, complaint, userID)
+	})
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to untag complaint",
+			"msg":   err.Error(),
+		})
+	}
+	if removed == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Complaint does not have this tag",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaint untagged successfully",
+		"complaintid": complaint.ID,
+	})
+}
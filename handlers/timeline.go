@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+type TimelineEntry struct {
+	Type   string    `json:"type"`
+	At     time.Time `json:"at"`
+	Author string    `json:"author,omitempty"`
+	Text   string    `json:"text"`
+}
+
+// GetComplaintTimeline returns the customer-facing history of a complaint:
+// when it was registered and its public comments, oldest first. Internal
+// notes are never included, whoever the caller is.
+func (h *Handlers) GetComplaintTimeline(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	var complaint tables.Complaints
+	result := h.db.
+		Preload("Comments", func(db *gorm.DB) *gorm.DB {
+			return db.Where("visibility = ? AND deleted_at IS NULL", tables.VisibilityPublic).
+				Order("comments.created_at ASC")
+		}).
+		Preload("Comments.CreatedBy", withDeleted).
+		First(&complaint, complaintID)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get complaint",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	timeline := []TimelineEntry{{
+		Type: "created",
+		At:   complaint.CreatedAt,
+		Text: complaint.Description,
+	}}
+	for _, comment := range complaint.Comments {
+		timeline = append(timeline, TimelineEntry{
+			Type:   "comment",
+			At:     comment.CreatedAt,
+			Author: comment.CreatedBy.Name,
+			Text:   comment.Comment,
+		})
+	}
+
+	return c.JSON(timeline)
+}
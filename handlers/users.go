@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+func newUserResponses(users []tables.Users) []UserResponse {
+	responses := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, newUserResponse(user))
+	}
+	return responses
+}
+
+// roleForEmail returns admin for addresses listed in ADMIN_EMAILS so a fresh
+// installation has someone who can administer users, and role otherwise.
+// Only a verified address counts, or anyone could register with it.
+func (h *Handlers) roleForEmail(email string, verified bool, role string) string {
+	if !verified {
+		return role
+	}
+	for _, admin := range h.config.AdminEmails {
+		if admin == email {
+			return tables.RoleAdmin
+		}
+	}
+	return role
+}
+
+func (h *Handlers) GetAllUsers(c *fiber.Ctx) error {
+	var users []tables.Users
+	result := h.db.Order("created_at").Find(&users)
+
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get users",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(newUserResponses(users))
+}
+
+func (h *Handlers) DeactivateUser(c *fiber.Ctx) error {
+	return h.setUserActive(c, false)
+}
+
+func (h *Handlers) ReactivateUser(c *fiber.Ctx) error {
+	return h.setUserActive(c, true)
+}
+
+func (h *Handlers) setUserActive(c *fiber.Ctx, active bool) error {
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID format",
+		})
+	}
+
+	if callerID, _ := currentUserID(c); callerID == uint(userID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot change your own active state",
+		})
+	}
+
+	result := h.db.Model(&tables.Users{}).Where("id = ?", userID).Update("active", active)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update user",
+			"msg":   result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	var user tables.Users
+	h.db.First(&user, userID)
+
+	return c.JSON(newUserResponse(user))
+}
+
+// DeleteUser reassigns the user's open complaints to ?reassignTo (or leaves
+// them unassigned), revokes their API keys and soft-deletes the user with
+// personal data scrubbed. The row is kept so complaints and comments they
+// wrote still have a valid author.
+func (h *Handlers) DeleteUser(c *fiber.Ctx) error {
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID format",
+		})
+	}
+
+	if callerID, _ := currentUserID(c); callerID == uint(userID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot delete yourself",
+		})
+	}
+
+	var reassignTo *uint
+	if value := c.Query("reassignTo"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == userID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid reassignTo user ID",
+			})
+		}
+
+		var target tables.Users
+		if result := h.db.First(&target, id); result.Error != nil || !target.Active {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "User to reassign to does not exist or is deactivated",
+			})
+		}
+		reassignTo = &target.ID
+	}
+
+	var user tables.Users
+	result := h.db.First(&user, userID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve user",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	var reassigned int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&tables.Complaints{}).
+			Where("assignee_id = ? AND status <> ?", user.ID, tables.Solved).
+			Updates(map[string]interface{}{
+				"assignee_id": reassignTo,
+				"version":     gorm.Expr("version + 1"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		reassigned = result.RowsAffected
+
+		if err := tx.Model(&tables.APIKeys{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", gorm.Expr("NOW()")).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"name":         "Deleted user",
+			"email":        fmt.Sprintf("deleted-%d@deleted.invalid", user.ID),
+			"password":     "",
+			"oidc_subject": nil,
+			"active":       false,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&user).Error
+	})
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete user",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":    "User deleted successfully",
+		"userid":     user.ID,
+		"reassigned": reassigned,
+	})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// follow makes a user watch a complaint. Following twice is a no-op.
+func follow(tx *gorm.DB, complaintID uint, userID *uint) error {
+	if userID == nil {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&tables.ComplaintWatchers{ComplaintID: complaintID, UserID: *userID}).Error
+}
+
+func (h *Handlers) WatchComplaint(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	var complaint tables.Complaints
+	result := h.db.First(&complaint, complaintID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Complaint not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load complaint",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	if err := follow(h.db.DB, complaintID, &userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to watch complaint",
+			"msg":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaint watched successfully",
+		"complaintid": complaintID,
+	})
+}
+
+func (h *Handlers) UnwatchComplaint(c *fiber.Ctx) error {
+	complaintID, err := h.complaintIDParam(c)
+	if err != nil {
+		return complaintIDFailed(c, err)
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized - Missing user",
+		})
+	}
+
+	result := h.db.Where("complaint_id = ? AND user_id = ?", complaintID, userID).Delete(&tables.ComplaintWatchers{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unwatch complaint",
+			"msg":   result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Complaint unwatched successfully",
+		"complaintid": complaintID,
+	})
+}
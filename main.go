@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
+	"github.com/pedersandvoll/Practice-Exam-BE/auth"
 	"github.com/pedersandvoll/Practice-Exam-BE/config"
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
 	"github.com/pedersandvoll/Practice-Exam-BE/handlers"
+	"github.com/pedersandvoll/Practice-Exam-BE/notify"
 	"github.com/pedersandvoll/Practice-Exam-BE/routes"
 	"github.com/pedersandvoll/Practice-Exam-BE/tables"
 )
@@ -18,6 +23,25 @@ func main() {
 	}
 
 	dbConfig := config.NewConfig()
+	if err := dbConfig.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	keys := auth.NewHMACKeySet(dbConfig.JWTSecret)
+	if len(dbConfig.JWTKeys) > 0 {
+		keys, err = auth.LoadKeySet(dbConfig.JWTKeys, dbConfig.JWTActiveKid)
+		if err != nil {
+			log.Fatalf("Could not load JWT signing keys: %v", err)
+		}
+		// Tokens signed with JWT_SECRET before the switch are still
+		// accepted until JWT_SECRET is removed.
+		if dbConfig.HasJWTSecret() {
+			if err := keys.AllowHMAC(dbConfig.JWTSecret); err != nil {
+				log.Fatalf("Could not load JWT signing keys: %v", err)
+			}
+		}
+	}
+
 	db, err := config.NewDatabase(dbConfig)
 	if err != nil {
 		log.Fatalf("Could not initialize database: %v", err)
@@ -25,11 +49,55 @@ func main() {
 
 	tables.RunMigrations(db.DB)
 
+	if len(dbConfig.AdminEmails) > 0 {
+		db.Model(&tables.Users{}).Where("email IN ? AND email_verified", dbConfig.AdminEmails).Update("role", tables.RoleAdmin)
+	}
+
 	app := fiber.New()
 
-	h := handlers.NewHandlers(db, dbConfig.JWTSecret)
+	var oidcProvider *auth.OIDCProvider
+	if dbConfig.OIDCIssuer != "" {
+		oidcProvider, err = auth.NewOIDCProvider(context.Background(), auth.OIDCConfig{
+			Issuer:       dbConfig.OIDCIssuer,
+			ClientID:     dbConfig.OIDCClientID,
+			ClientSecret: dbConfig.OIDCClientSecret,
+			RedirectURL:  dbConfig.OIDCRedirectURL,
+			Scopes:       dbConfig.OIDCScopes,
+			GroupsClaim:  dbConfig.OIDCGroupsClaim,
+		})
+		if err != nil {
+			log.Fatalf("Could not initialize single sign-on: %v", err)
+		}
+	}
+
+	var mailer notify.Mailer = notify.LogMailer{}
+	if dbConfig.SMTPHost != "" {
+		mailer = &notify.SMTPMailer{
+			Addr:     dbConfig.SMTPHost + ":" + dbConfig.SMTPPort,
+			From:     dbConfig.SMTPFrom,
+			Username: dbConfig.SMTPUsername,
+			Password: dbConfig.SMTPPassword,
+		}
+	}
+	emailNotifier := notify.NewEmailNotifier(db.DB, mailer, dbConfig.AppURL)
+	if dbConfig.EmailDigestMinutes > 0 {
+		go emailNotifier.RunDigests(context.Background(), time.Duration(dbConfig.EmailDigestMinutes)*time.Minute)
+	} else {
+		log.Println("EMAIL_DIGEST_MINUTES is not positive, digest emails are not sent")
+	}
+
+	broker := events.NewBroker(1000)
+	go broker.Listen(context.Background(), db.DB)
+
+	dispatcher := events.NewDispatcher(db.DB, 500*time.Millisecond)
+	dispatcher.Subscribe("email", emailNotifier)
+	dispatcher.Subscribe("inbox", notify.NewInboxNotifier(db.DB))
+	go dispatcher.Run(context.Background())
+
+	h := handlers.NewHandlers(db, dbConfig, keys, oidcProvider, broker, mailer)
+	go h.RunPurge(context.Background(), time.Duration(dbConfig.ComplaintRetentionDays)*24*time.Hour)
 
-	routes.Routes(app, h)
+	routes.Routes(app, h, db)
 
 	app.Listen(":3000")
 }
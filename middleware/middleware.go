@@ -1,12 +1,26 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/pedersandvoll/Practice-Exam-BE/auth"
+	"github.com/pedersandvoll/Practice-Exam-BE/config"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"github.com/pedersandvoll/Practice-Exam-BE/utils"
+	"gorm.io/gorm"
 )
 
-func AuthRequired(jwtSecret []byte) fiber.Handler {
+func AuthRequired(keys *auth.KeySet, db *config.Database) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		// Requests already authenticated by APIKeyAuth don't carry a JWT.
+		if c.Locals("apikey") != nil {
+			return c.Next()
+		}
+
 		authHeader := c.Get("Authorization")
 		if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -16,9 +30,7 @@ func AuthRequired(jwtSecret []byte) fiber.Handler {
 
 		tokenString := authHeader[7:]
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := keys.Parse(tokenString)
 
 		if err != nil || !token.Valid {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -26,11 +38,114 @@ func AuthRequired(jwtSecret []byte) fiber.Handler {
 			})
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Locals("username", claims["username"])
-			c.Locals("email", claims["email"])
-			c.Locals("userid", claims["userid"])
-			c.Locals("user", token)
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid token",
+			})
+		}
+
+		// Look the user up on every request so deactivation and role
+		// changes take effect without waiting for the token to expire.
+		userID, ok := claims["userid"].(float64)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid token",
+			})
+		}
+
+		var user tables.Users
+		result := db.First(&user, uint(userID))
+		if result.Error != nil || !user.Active {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "User is deactivated or no longer exists",
+			})
+		}
+
+		c.Locals("username", claims["username"])
+		c.Locals("email", claims["email"])
+		c.Locals("userid", claims["userid"])
+		c.Locals("role", user.Role)
+		c.Locals("user", token)
+
+		return c.Next()
+	}
+}
+
+// APIKeyAuth authenticates requests carrying an X-API-Key header. Requests
+// without the header are passed on untouched so AuthRequired can handle them.
+func APIKeyAuth(db *config.Database) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("X-API-Key")
+		if key == "" {
+			return c.Next()
+		}
+
+		var apiKey tables.APIKeys
+		result := db.Preload("User").
+			Where("key_hash = ? AND revoked_at IS NULL", utils.HashToken(key)).
+			First(&apiKey)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid API key",
+			})
+		}
+		if result.Error != nil {
+			fmt.Println("Database error:", result.Error)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check API key",
+			})
+		}
+
+		if apiKey.User.ID == 0 || !apiKey.User.Active {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "API key owner is deactivated or no longer exists",
+			})
+		}
+
+		scope := tables.ScopeWrite
+		if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+			scope = tables.ScopeRead
+		}
+		if !apiKey.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API key is missing the " + scope + " scope",
+			})
+		}
+
+		db.Model(&apiKey).UpdateColumn("last_used_at", time.Now())
+
+		c.Locals("username", apiKey.User.Name)
+		c.Locals("email", apiKey.User.Email)
+		c.Locals("userid", apiKey.UserID)
+		c.Locals("role", apiKey.User.Role)
+		c.Locals("apikey", apiKey)
+
+		return c.Next()
+	}
+}
+
+// StaffRequired keeps users with the customer role out of routes that change
+// complaints. It must run after AuthRequired.
+func StaffRequired() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Locals("role") == tables.RoleCustomer {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Customers can't change complaints",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// AdminRequired only lets admins through. It must run after AuthRequired.
+func AdminRequired() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Locals("role") != tables.RoleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Admin access required",
+			})
 		}
 
 		return c.Next()
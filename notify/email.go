@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EmailNotifier is an outbox subscriber that emails the people involved in
+// a complaint when it is commented on, edited or assigned.
+type EmailNotifier struct {
+	db      *gorm.DB
+	mailer  Mailer
+	baseURL string
+}
+
+func NewEmailNotifier(db *gorm.DB, mailer Mailer, baseURL string) *EmailNotifier {
+	return &EmailNotifier{db: db, mailer: mailer, baseURL: baseURL}
+}
+
+func (n *EmailNotifier) Handle(ctx context.Context, event events.Event) error {
+	if !Has(event.Type) {
+		return nil
+	}
+	db := n.db.WithContext(ctx)
+
+	data, err := loadEventData(db, event, n.baseURL)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	recipients, err := eventRecipients(db, event)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recipients {
+		if err := n.enqueue(db, event, r, data); err != nil {
+			return err
+		}
+	}
+
+	return n.sendPending(db, event.ID)
+}
+
+func (n *EmailNotifier) enqueue(db *gorm.DB, event events.Event, r recipient, data eventData) error {
+	userID := r.userID
+	if err := db.Where("active = ?", true).First(&data.Recipient, userID).Error; err != nil {
+		return nil
+	}
+	// Emails quote the comment, so customers never get one for an internal note.
+	if hiddenFrom(data.Recipient, data.Comment) {
+		return nil
+	}
+
+	mode := tables.EmailImmediate
+	var prefs tables.NotificationPreferences
+	if db.Limit(1).Find(&prefs, "user_id = ?", userID).RowsAffected > 0 {
+		mode = prefs.EmailMode
+	}
+	if mode == tables.EmailOff {
+		return nil
+	}
+
+	subject, body, err := Render(r.template, data)
+	if err != nil {
+		return err
+	}
+
+	// The unique (user, event) index makes redelivery of the event a no-op.
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tables.EmailNotifications{
+		UserID:  userID,
+		EventID: uint(event.ID),
+		Subject: subject,
+		Body:    body,
+		Digest:  mode == tables.EmailDigest,
+	}).Error
+}
+
+func (n *EmailNotifier) sendPending(db *gorm.DB, eventID uint64) error {
+	var pending []tables.EmailNotifications
+	if err := db.Where("event_id = ? AND digest = ? AND sent_at IS NULL", eventID, false).Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for _, email := range pending {
+		var user tables.Users
+		if err := db.First(&user, email.UserID).Error; err != nil {
+			continue
+		}
+		if err := n.mailer.Send(user.Email, email.Subject, email.Body); err != nil {
+			return err
+		}
+		if err := db.Model(&email).Update("sent_at", time.Now()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunDigests sends every user with digest mode one email per interval
+// summarising their pending notifications, until ctx is cancelled.
+func (n *EmailNotifier) RunDigests(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.sendDigests(ctx); err != nil {
+				log.Println("Digest error:", err)
+			}
+		}
+	}
+}
+
+func (n *EmailNotifier) sendDigests(ctx context.Context) error {
+	db := n.db.WithContext(ctx)
+
+	var userIDs []uint
+	if err := db.Model(&tables.EmailNotifications{}).
+		Where("digest = ? AND sent_at IS NULL", true).
+		Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		var user tables.Users
+		if err := db.First(&user, userID).Error; err != nil {
+			continue
+		}
+
+		var items []tables.EmailNotifications
+		if err := db.Where("user_id = ? AND digest = ? AND sent_at IS NULL", userID, true).
+			Order("created_at").Find(&items).Error; err != nil {
+			return err
+		}
+
+		subject, body, err := Render("digest", map[string]interface{}{"Recipient": user, "Items": items})
+		if err != nil {
+			return err
+		}
+		if err := n.mailer.Send(user.Email, subject, body); err != nil {
+			return err
+		}
+
+		ids := make([]uint, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		if err := db.Model(&tables.EmailNotifications{}).Where("id IN ?", ids).Update("sent_at", time.Now()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
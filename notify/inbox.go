@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InboxNotifier is an outbox subscriber that creates in-app notifications
+// for the same events and recipients as the email notifications.
+type InboxNotifier struct {
+	db *gorm.DB
+}
+
+func NewInboxNotifier(db *gorm.DB) *InboxNotifier {
+	return &InboxNotifier{db: db}
+}
+
+func (n *InboxNotifier) Handle(ctx context.Context, event events.Event) error {
+	if !Has(event.Type) {
+		return nil
+	}
+	db := n.db.WithContext(ctx)
+
+	data, err := loadEventData(db, event, "")
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var commentID *uint
+	if event.CommentID != 0 {
+		commentID = &event.CommentID
+	}
+
+	recipients, err := eventRecipients(db, event)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recipients {
+		var user tables.Users
+		if err := db.Select("id", "role").First(&user, r.userID).Error; err != nil {
+			continue
+		}
+		if hiddenFrom(user, data.Comment) {
+			continue
+		}
+
+		// The email subject doubles as the notification message.
+		message, _, err := Render(r.template, data)
+		if err != nil {
+			return err
+		}
+
+		notification := tables.Notifications{
+			UserID:      r.userID,
+			EventID:     uint(event.ID),
+			Type:        r.template,
+			ComplaintID: event.ComplaintID,
+			CommentID:   commentID,
+			ActorID:     event.ActorID,
+			Message:     message,
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
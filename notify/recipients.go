@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"fmt"
+
+	"github.com/pedersandvoll/Practice-Exam-BE/events"
+	"github.com/pedersandvoll/Practice-Exam-BE/tables"
+	"gorm.io/gorm"
+)
+
+// CommentMentioned is the template used instead of comment.created for
+// users mentioned in the comment. It is also the type of the event for users
+// newly mentioned by an edit.
+const CommentMentioned = events.CommentMentioned
+
+type eventData struct {
+	Recipient tables.Users
+	Actor     tables.Users
+	Complaint tables.Complaints
+	Comment   tables.Comments
+	URL       string
+}
+
+// loadEventData loads what the templates need to describe an event. The
+// complaint may have been deleted since the event was recorded, so deleted
+// complaints are loaded too. gorm.ErrRecordNotFound means the complaint or
+// comment has since been purged and there is nothing left to notify about.
+func loadEventData(db *gorm.DB, event events.Event, baseURL string) (eventData, error) {
+	data := eventData{URL: fmt.Sprintf("%s/complaints/%d", baseURL, event.ComplaintID)}
+	if err := db.Unscoped().Preload("Customer").First(&data.Complaint, event.ComplaintID).Error; err != nil {
+		return data, err
+	}
+	if err := db.Unscoped().First(&data.Actor, event.ActorID).Error; err != nil {
+		return data, err
+	}
+	if event.CommentID != 0 {
+		if err := db.First(&data.Comment, event.CommentID).Error; err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// hiddenFrom reports whether the event's comment is an internal note the
+// user may not see. Customers only see public comments.
+func hiddenFrom(user tables.Users, comment tables.Comments) bool {
+	return user.Role == tables.RoleCustomer && comment.ID != 0 && comment.Visibility != tables.VisibilityPublic
+}
+
+// Watchers returns the IDs of the users watching a complaint.
+func Watchers(db *gorm.DB, complaintID uint) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&tables.ComplaintWatchers{}).Where("complaint_id = ?", complaintID).Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+type recipient struct {
+	userID   uint
+	template string
+}
+
+// eventRecipients returns who should be notified about an event and with
+// which template, leaving out the user who caused it. Assignments only
+// notify the new assignee and added mentions only the users added. Users
+// mentioned in a comment get a mention notification, and everything else
+// notifies the complaint's watchers.
+// Each user is only listed once, with the most specific template.
+func eventRecipients(db *gorm.DB, event events.Event) ([]recipient, error) {
+	var recipients []recipient
+	seen := map[uint]bool{event.ActorID: true}
+	add := func(userID uint, template string) {
+		if !seen[userID] {
+			seen[userID] = true
+			recipients = append(recipients, recipient{userID: userID, template: template})
+		}
+	}
+
+	if event.Type == events.ComplaintAssigned {
+		if event.AssigneeID != nil {
+			add(*event.AssigneeID, event.Type)
+		}
+		return recipients, nil
+	}
+
+	for _, userID := range event.Mentions {
+		add(userID, CommentMentioned)
+	}
+	if event.Type == events.CommentMentioned {
+		return recipients, nil
+	}
+
+	watchers, err := Watchers(db, event.ComplaintID)
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range watchers {
+		add(userID, event.Type)
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"strings"
+	"text/template"
+)
+
+// Each template renders the subject on the first line and the body after it.
+var templates = template.Must(template.New("notify").Parse(`
+{{define "comment.created"}}New comment on complaint {{.Complaint.Reference}}
+{{.Actor.Name}} commented on complaint {{.Complaint.Reference}} from {{.Complaint.Customer.Name}}:
+
+{{.Comment.Comment}}
+
+{{.URL}}
+{{end}}
+
+{{define "comment.mentioned"}}{{.Actor.Name}} mentioned you on complaint {{.Complaint.Reference}}
+{{.Actor.Name}} mentioned you in a comment on complaint {{.Complaint.Reference}} from {{.Complaint.Customer.Name}}:
+
+{{.Comment.Comment}}
+
+{{.URL}}
+{{end}}
+
+{{define "complaint.updated"}}Complaint {{.Complaint.Reference}} was updated
+{{.Actor.Name}} updated complaint {{.Complaint.Reference}} from {{.Complaint.Customer.Name}}.
+
+Status: {{.Complaint.Status}}
+Priority: {{.Complaint.Priority}}
+
+{{.URL}}
+{{end}}
+
+{{define "complaint.assigned"}}Complaint {{.Complaint.Reference}} was assigned to you
+{{.Actor.Name}} assigned complaint {{.Complaint.Reference}} from {{.Complaint.Customer.Name}} to you.
+
+{{.Complaint.Description}}
+
+{{.URL}}
+{{end}}
+
+{{define "digest"}}{{len .Items}} complaint update(s)
+Hi {{.Recipient.Name}}, here is what happened since your last digest:
+{{range .Items}}
+- {{.Subject}}{{end}}
+{{end}}
+
+{{define "email.verify"}}Confirm your new email address
+Hi {{.Recipient.Name}},
+
+Open the link below to confirm {{.Recipient.PendingEmail}} as your new email address:
+
+{{.URL}}
+{{end}}
+`))
+
+// Render executes the named template and splits the result into subject and
+// body.
+func Render(name string, data interface{}) (subject, body string, err error) {
+	var out strings.Builder
+	if err := templates.ExecuteTemplate(&out, name, data); err != nil {
+		return "", "", err
+	}
+	subject, body, _ = strings.Cut(strings.TrimLeft(out.String(), "\n"), "\n")
+	return subject, body, nil
+}
+
+// Has reports whether there is an email template for the event type.
+func Has(name string) bool {
+	return templates.Lookup(name) != nil
+}
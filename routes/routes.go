@@ -3,33 +3,94 @@ package routes
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/pedersandvoll/Practice-Exam-BE/config"
 	"github.com/pedersandvoll/Practice-Exam-BE/handlers"
 	"github.com/pedersandvoll/Practice-Exam-BE/middleware"
 )
 
-func Routes(app *fiber.App, h *handlers.Handlers) {
+func Routes(app *fiber.App, h *handlers.Handlers, db *config.Database) {
 	app.Use(cors.New())
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Hello, GORM with PostgreSQL!")
 	})
 	app.Post("/register", h.RegisterUser)
 	app.Post("/login", h.LoginUser)
+	app.Get("/auth/oidc/login", h.OIDCLogin)
+	app.Get("/auth/oidc/callback", h.OIDCCallback)
+	app.Get("/.well-known/jwks.json", h.GetJWKS)
+	app.Get("/verify-email", h.VerifyEmail)
 
 	api := app.Group("/api")
-	api.Use(middleware.AuthRequired(h.JWTSecret))
+	api.Use(middleware.APIKeyAuth(db))
+	api.Use(middleware.AuthRequired(h.Keys, db))
 
 	api.Get("/users", h.GetUsers)
 
+	api.Get("/me", h.GetMe)
+	api.Put("/me", h.UpdateMe)
+	api.Post("/me/password", h.ChangePassword)
+	api.Get("/me/notification-preferences", h.GetNotificationPreferences)
+	api.Put("/me/notification-preferences", h.UpdateNotificationPreferences)
+
+	api.Get("/notifications", h.GetNotifications)
+	api.Get("/notifications/unread-count", h.GetUnreadNotificationCount)
+	api.Post("/notifications/read-all", h.MarkAllNotificationsRead)
+	api.Put("/notifications/:id/read", h.MarkNotificationRead)
+	api.Put("/notifications/:id/unread", h.MarkNotificationUnread)
+
 	api.Post("/customers/create", h.RegisterCustomer)
+	api.Put("/customers/edit/:id", h.EditCustomer)
 	api.Get("/customers", h.GetCustomers)
 
 	api.Post("/complaints/create", h.RegisterComplaint)
-	api.Put("/complaints/edit/:id", h.EditComplaint)
+	api.Put("/complaints/edit/:id", middleware.StaffRequired(), h.EditComplaint)
+	api.Post("/complaints/bulk", middleware.StaffRequired(), h.BulkUpdateComplaints)
+	api.Patch("/complaints/:id", middleware.StaffRequired(), h.PatchComplaint)
+	api.Delete("/complaints/:id", h.DeleteComplaint)
+	api.Get("/complaints/stream", h.StreamComplaints)
 	api.Get("/complaints/:id", h.GetComplaintById)
+	api.Get("/complaints/:id/timeline", h.GetComplaintTimeline)
+	api.Get("/complaints/:id/comments", h.GetComplaintComments)
+	api.Get("/complaints/:id/history", h.GetComplaintHistory)
 	api.Get("/complaints", h.GetComplaints)
+	api.Post("/complaints/:id/watch", h.WatchComplaint)
+	api.Delete("/complaints/:id/watch", h.UnwatchComplaint)
+	api.Post("/complaints/:id/merge", h.MergeComplaints)
+	api.Post("/complaints/:id/unmerge", h.UnmergeComplaint)
+	api.Get("/complaints/:id/merges", h.GetComplaintMerges)
+	api.Post("/complaints/:id/links", middleware.StaffRequired(), h.AddComplaintLink)
+	api.Delete("/complaints/:id/links/:linkId", middleware.StaffRequired(), h.DeleteComplaintLink)
+	api.Post("/complaints/:id/tags", middleware.StaffRequired(), h.TagComplaint)
+	api.Delete("/complaints/:id/tags/:tagId", middleware.StaffRequired(), h.UntagComplaint)
 
 	api.Post("/comments/create/:id", h.AddComplaintComment)
+	api.Put("/comments/edit/:id", h.EditComment)
+	api.Delete("/comments/:id", h.DeleteComment)
+	api.Get("/comments/:id/revisions", h.GetCommentRevisions)
 
 	api.Post("/categories/create", h.RegisterCategory)
+	api.Put("/categories/edit/:id", h.EditCategory)
 	api.Get("/categories", h.GetCategories)
+
+	api.Get("/tags", h.GetTags)
+
+	api.Get("/statistics", h.GetStatistics)
+
+	admin := api.Group("/admin", middleware.AdminRequired())
+	admin.Get("/users", h.GetAllUsers)
+	admin.Post("/users/:id/deactivate", h.DeactivateUser)
+	admin.Post("/users/:id/reactivate", h.ReactivateUser)
+	admin.Delete("/users/:id", h.DeleteUser)
+	admin.Get("/complaints/deleted", h.GetDeletedComplaints)
+	admin.Post("/complaints/:id/restore", h.RestoreComplaint)
+	admin.Post("/tags/create", h.CreateTag)
+	admin.Put("/tags/edit/:id", h.EditTag)
+	admin.Delete("/tags/:id", h.DeleteTag)
+	admin.Post("/custom-fields/create", h.CreateCustomField)
+	admin.Put("/custom-fields/edit/:id", h.EditCustomField)
+	admin.Delete("/custom-fields/:id", h.DeleteCustomField)
+
+	api.Post("/apikeys/create", h.CreateAPIKey)
+	api.Get("/apikeys", h.GetAPIKeys)
+	api.Post("/apikeys/revoke/:id", h.RevokeAPIKey)
 }
@@ -1,6 +1,12 @@
 package tables
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,6 +20,18 @@ const (
 	Low
 )
 
+func (p Priority) String() string {
+	switch p {
+	case High:
+		return "High"
+	case Medium:
+		return "Medium"
+	case Low:
+		return "Low"
+	}
+	return "Unknown"
+}
+
 type Status int
 
 const (
@@ -22,22 +40,55 @@ const (
 	Solved
 )
 
+func (s Status) String() string {
+	switch s {
+	case New:
+		return "New"
+	case UnderTreatment:
+		return "UnderTreatment"
+	case Solved:
+		return "Solved"
+	}
+	return "Unknown"
+}
+
+const (
+	RoleAdmin    = "admin"
+	RoleAgent    = "agent"
+	RoleCustomer = "customer"
+)
+
 type Users struct {
-	ID        uint      `gorm:"primaryKey"`
-	Name      string    `gorm:"size:100"`
-	Email     string    `gorm:"uniqueIndex"`
-	Password  string    `gorm:"type:text"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ID          uint           `gorm:"primaryKey"`
+	Name        string         `gorm:"size:100"`
+	Email       string         `gorm:"uniqueIndex"`
+	Password    string         `gorm:"type:text" json:"-"`
+	Role        string         `gorm:"size:20;default:agent"`
+	OIDCSubject *string        `gorm:"column:oidc_subject;uniqueIndex" json:"-"`
+	Active      bool           `gorm:"default:true"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// EmailVerified is set once the user has confirmed Email, or the
+	// identity provider has vouched for it.
+	EmailVerified            bool       `gorm:"not null;default:false" json:"-"`
+	PendingEmail             string     `gorm:"size:255" json:"-"`
+	EmailVerificationHash    string     `gorm:"index" json:"-"`
+	EmailVerificationExpires *time.Time `json:"-"`
 }
 
 type Customers struct {
 	ID        uint      `gorm:"primaryKey"`
 	Name      string    `gorm:"size:100;uniqueIndex"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
+	Version   uint      `gorm:"not null;default:1"`
 }
 
 type Complaints struct {
-	ID            uint      `gorm:"primaryKey"`
+	ID uint `gorm:"primaryKey"`
+	// Reference is the number customers and agents use for the complaint,
+	// such as CMP-2026-000123.
+	Reference     string    `gorm:"size:20;uniqueIndex"`
 	CustomerID    uint      `gorm:"not null"`
 	Customer      Customers `gorm:"foreignKey:CustomerID"`
 	Description   string    `gorm:"type:text"`
@@ -46,34 +97,377 @@ type Complaints struct {
 	ModifiedAt    time.Time `gorm:"autoUpdateTime"`
 	CreatedByID   uint      `gorm:"not null"`
 	CreatedBy     Users     `gorm:"foreignKey:CreatedByID"`
+	AssigneeID    *uint
+	Assignee      *Users `gorm:"foreignKey:AssigneeID"`
 	Priority      Priority
 	Status        Status
 	Comments      []Comments `gorm:"foreignKey:ComplaintID"`
+	// CommentCount and LastCommentAt are filled in by the complaint list
+	// query; they aren't stored.
+	CommentCount  int64      `gorm:"->;-:migration"`
+	LastCommentAt *time.Time `gorm:"->;-:migration"`
+	Watchers      []Users    `gorm:"many2many:complaint_watchers;joinForeignKey:ComplaintID;joinReferences:UserID"`
+	Tags          []Tags     `gorm:"many2many:complaint_tags;joinForeignKey:ComplaintID;joinReferences:TagID"`
 	CategoryId    uint       `gorm:"not null"`
 	Category      Categories `gorm:"foreignKey:CategoryId"`
+	// Version is bumped on every update and is the complaint's ETag.
+	Version     uint           `gorm:"not null;default:1"`
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	DeletedByID *uint
+	// MergedIntoID is set on duplicates that were merged into another
+	// complaint.
+	MergedIntoID *uint `gorm:"index"`
+	// CustomFields holds the values of the custom fields defined for the
+	// complaint's category, keyed by field key.
+	CustomFields CustomFieldValues `gorm:"type:jsonb;not null;default:'{}'"`
 }
 
-type Comments struct {
+// CustomFieldValues is stored as a JSONB object. Text, date and enum values
+// are strings, dates formatted as YYYY-MM-DD, and numbers are float64.
+type CustomFieldValues map[string]interface{}
+
+func (v CustomFieldValues) Value() (driver.Value, error) {
+	if v == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (v *CustomFieldValues) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, v)
+	case string:
+		return json.Unmarshal([]byte(data), v)
+	case nil:
+		*v = CustomFieldValues{}
+		return nil
+	}
+	return errors.New("unsupported custom field values type")
+}
+
+// ReferenceSequence numbers complaint references. The number keeps counting
+// across years; only the year in the reference changes.
+const ReferenceSequence = "complaint_reference_seq"
+
+// ComplaintReference formats a reference number for a complaint created in
+// the given year.
+func ComplaintReference(year int, number int64) string {
+	return fmt.Sprintf("CMP-%d-%06d", year, number)
+}
+
+// ComplaintHistory records a change to one field of a complaint. Changes
+// made by the same bulk update share a BatchID.
+type ComplaintHistory struct {
 	ID          uint      `gorm:"primaryKey"`
-	Comment     string    `gorm:"type:text"`
-	ComplaintID uint      `gorm:"not null"`
+	ComplaintID uint      `gorm:"not null;index"`
+	Field       string    `gorm:"size:50"`
+	OldValue    string    `gorm:"type:text"`
+	NewValue    string    `gorm:"type:text"`
+	ChangedByID uint      `gorm:"not null"`
+	ChangedBy   Users     `gorm:"foreignKey:ChangedByID"`
+	BatchID     string    `gorm:"size:64;index"`
 	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// ComplaintMerges records a duplicate complaint being merged into a primary
+// one, with what is needed to undo it: the comments that were moved, the
+// watchers the primary gained, the duplicate-of link if the merge created it
+// and the duplicate's status before it was closed.
+type ComplaintMerges struct {
+	ID             uint                     `gorm:"primaryKey"`
+	PrimaryID      uint                     `gorm:"not null;index"`
+	DuplicateID    uint                     `gorm:"not null;index"`
+	PreviousStatus Status                   `gorm:"not null"`
+	MovedComments  []ComplaintMergeComments `gorm:"foreignKey:MergeID"`
+	AddedWatchers  []ComplaintMergeWatchers `gorm:"foreignKey:MergeID"`
+	MergedByID     uint                     `gorm:"not null"`
+	MergedBy       Users                    `gorm:"foreignKey:MergedByID"`
+	CreatedAt      time.Time                `gorm:"autoCreateTime"`
+	RevertedAt     *time.Time
+	RevertedByID   *uint
+	LinkID         *uint
+}
+
+type ComplaintMergeComments struct {
+	MergeID   uint `gorm:"primaryKey"`
+	CommentID uint `gorm:"primaryKey"`
+}
+
+type ComplaintMergeWatchers struct {
+	MergeID uint `gorm:"primaryKey"`
+	UserID  uint `gorm:"primaryKey"`
+}
+
+const (
+	LinkRelatesTo   = "relates-to"
+	LinkDuplicateOf = "duplicate-of"
+	LinkBlocks      = "blocks"
+	LinkCausedBy    = "caused-by"
+)
+
+// ComplaintLinks is a typed link from one complaint to another, read as
+// "source <type> target", e.g. "1 blocks 2".
+type ComplaintLinks struct {
+	ID          uint      `gorm:"primaryKey"`
+	SourceID    uint      `gorm:"not null;uniqueIndex:idx_complaint_links_unique"`
+	TargetID    uint      `gorm:"not null;uniqueIndex:idx_complaint_links_unique;index"`
+	Type        string    `gorm:"size:20;not null;uniqueIndex:idx_complaint_links_unique"`
 	CreatedByID uint      `gorm:"not null"`
-	CreatedBy   Users     `gorm:"foreignKey:CreatedByID"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+type ComplaintWatchers struct {
+	ComplaintID uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"primaryKey;index"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+type Comments struct {
+	ID          uint       `gorm:"primaryKey"`
+	Comment     string     `gorm:"type:text"`
+	ComplaintID uint       `gorm:"not null"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+	CreatedByID uint       `gorm:"not null"`
+	CreatedBy   Users      `gorm:"foreignKey:CreatedByID"`
+	Mentions    []Users    `gorm:"many2many:comment_mentions;joinForeignKey:CommentID;joinReferences:UserID"`
+	Visibility  string     `gorm:"size:20;not null;default:public;index"`
+	ParentID    *uint      `gorm:"index"`
+	Depth       int        `gorm:"not null;default:0"`
+	Replies     []Comments `gorm:"foreignKey:ParentID" json:",omitempty"`
+	EditedAt    *time.Time
+	// DeletedAt is deliberately not a gorm.DeletedAt: deleted comments stay
+	// in the thread as placeholders instead of disappearing from queries.
+	DeletedAt   *time.Time
+	DeletedByID *uint
+}
+
+type CommentRevisions struct {
+	ID         uint      `gorm:"primaryKey"`
+	CommentID  uint      `gorm:"not null;index"`
+	Comment    string    `gorm:"type:text"`
+	EditedByID uint      `gorm:"not null"`
+	EditedBy   Users     `gorm:"foreignKey:EditedByID"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+const (
+	VisibilityInternal = "internal"
+	VisibilityPublic   = "public"
+)
+
+type CommentMentions struct {
+	CommentID uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"primaryKey;index"`
+}
+
+type APIKeys struct {
+	ID         uint   `gorm:"primaryKey"`
+	Name       string `gorm:"size:100"`
+	Prefix     string `gorm:"size:16"`
+	KeyHash    string `gorm:"uniqueIndex" json:"-"`
+	Scopes     string `gorm:"type:text"`
+	UserID     uint   `gorm:"not null"`
+	User       Users  `gorm:"foreignKey:UserID" json:"-"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	// ScopeInternal lets a key see internal notes. Keys without it only see
+	// customer-visible comments.
+	ScopeInternal = "internal"
+)
+
+func (k APIKeys) HasScope(scope string) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
 }
 
 type Categories struct {
+	ID           uint                     `gorm:"primaryKey"`
+	Name         string                   `gorm:"type:text"`
+	CreatedAt    time.Time                `gorm:"autoCreateTime"`
+	Version      uint                     `gorm:"not null;default:1"`
+	CustomFields []CustomFieldDefinitions `gorm:"foreignKey:CategoryID" json:",omitempty"`
+}
+
+const (
+	FieldText   = "text"
+	FieldNumber = "number"
+	FieldDate   = "date"
+	FieldEnum   = "enum"
+)
+
+// CustomFieldDefinitions are admin-defined fields that complaints in a
+// category carry, such as an order number. Options is the comma-separated
+// list of allowed values of an enum field.
+type CustomFieldDefinitions struct {
+	ID         uint      `gorm:"primaryKey"`
+	CategoryID uint      `gorm:"not null;uniqueIndex:idx_custom_fields_category_key"`
+	Key        string    `gorm:"size:50;not null;uniqueIndex:idx_custom_fields_category_key"`
+	Label      string    `gorm:"size:100"`
+	Type       string    `gorm:"size:20;not null"`
+	Options    string    `gorm:"type:text"`
+	Required   bool      `gorm:"not null;default:false"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+func (d CustomFieldDefinitions) OptionList() []string {
+	if d.Options == "" {
+		return nil
+	}
+	return strings.Split(d.Options, ",")
+}
+
+// Tags are admin-curated labels. A complaint can have any number of them.
+type Tags struct {
 	ID        uint      `gorm:"primaryKey"`
-	Name      string    `gorm:"type:text"`
+	Name      string    `gorm:"size:50;not null"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 }
 
+type ComplaintTags struct {
+	ComplaintID uint      `gorm:"primaryKey"`
+	TagID       uint      `gorm:"primaryKey;index"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+type OutboxEvents struct {
+	ID            uint   `gorm:"primaryKey"`
+	Type          string `gorm:"size:100;index"`
+	ComplaintID   uint   `gorm:"index"`
+	Payload       string `gorm:"type:jsonb"`
+	Attempts      int
+	LastError     string    `gorm:"type:text"`
+	NextAttemptAt time.Time `gorm:"index"`
+	ProcessedAt   *time.Time
+	FailedAt      *time.Time
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+}
+
+// OutboxDeliveries records which subscribers have already handled an outbox
+// event, so a retry only redelivers to the subscribers that failed.
+type OutboxDeliveries struct {
+	EventID     uint      `gorm:"primaryKey"`
+	Subscriber  string    `gorm:"primaryKey;size:100"`
+	DeliveredAt time.Time `gorm:"autoCreateTime"`
+}
+
+const (
+	EmailImmediate = "immediate"
+	EmailDigest    = "digest"
+	EmailOff       = "off"
+)
+
+type NotificationPreferences struct {
+	UserID    uint      `gorm:"primaryKey"`
+	EmailMode string    `gorm:"size:20;default:immediate"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// EmailNotifications holds rendered notification emails. Immediate emails
+// are sent as soon as they are created, digest emails are collected and sent
+// together by the digest worker.
+type EmailNotifications struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_email_notifications_user_event"`
+	EventID   uint   `gorm:"not null;uniqueIndex:idx_email_notifications_user_event"`
+	Subject   string `gorm:"type:text"`
+	Body      string `gorm:"type:text"`
+	Digest    bool
+	SentAt    *time.Time `gorm:"index"`
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
+
+type Notifications struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_notifications_user_event;index:idx_notifications_user_read"`
+	EventID     uint   `gorm:"not null;uniqueIndex:idx_notifications_user_event"`
+	Type        string `gorm:"size:100"`
+	ComplaintID uint   `gorm:"not null"`
+	CommentID   *uint
+	ActorID     uint
+	Actor       Users      `gorm:"foreignKey:ActorID"`
+	Message     string     `gorm:"type:text"`
+	ReadAt      *time.Time `gorm:"index:idx_notifications_user_read"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+}
+
 func RunMigrations(db *gorm.DB) {
+	// pg_trgm provides the similarity matching used to find duplicate
+	// complaints. Creating it needs a privileged role; without it
+	// everything else works, but duplicates are never found.
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Println("Could not enable pg_trgm, duplicate complaints won't be detected:", err)
+	}
+	// Reference numbers come from a sequence, so concurrent complaints never
+	// get the same one. Numbers taken by a rolled back insert are skipped.
+	db.Exec("CREATE SEQUENCE IF NOT EXISTS " + ReferenceSequence)
+
+	db.SetupJoinTable(&Complaints{}, "Watchers", &ComplaintWatchers{})
+	db.SetupJoinTable(&Comments{}, "Mentions", &CommentMentions{})
+	db.SetupJoinTable(&Complaints{}, "Tags", &ComplaintTags{})
+	hadWatchers := db.Migrator().HasTable(&ComplaintWatchers{})
+	hadVisibility := db.Migrator().HasColumn(&Comments{}, "Visibility")
+
 	db.AutoMigrate(
 		&Users{},
 		&Customers{},
 		&Complaints{},
 		&Comments{},
 		&Categories{},
+		&APIKeys{},
+		&OutboxEvents{},
+		&OutboxDeliveries{},
+		&NotificationPreferences{},
+		&EmailNotifications{},
+		&Notifications{},
+		&ComplaintWatchers{},
+		&CommentMentions{},
+		&CommentRevisions{},
+		&ComplaintHistory{},
+		&ComplaintMerges{},
+		&ComplaintMergeComments{},
+		&ComplaintMergeWatchers{},
+		&ComplaintLinks{},
+		&Tags{},
+		&ComplaintTags{},
+		&CustomFieldDefinitions{},
 	)
+
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_name_lower ON tags (LOWER(name))")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_complaints_description_trgm ON complaints USING gin (description gin_trgm_ops)")
+
+	// Complaints created before reference numbers existed get one in ID
+	// order, with the year they were created in.
+	db.Exec(`UPDATE complaints SET reference = numbered.reference FROM (
+			SELECT id, 'CMP-' || to_char(created_at, 'YYYY') || '-' || lpad(n::text, GREATEST(6, length(n::text)), '0') AS reference
+			FROM (SELECT id, created_at, nextval('` + ReferenceSequence + `') AS n
+				FROM (SELECT id, created_at FROM complaints WHERE reference IS NULL OR reference = '' ORDER BY id) pending) sequenced
+		) numbered
+		WHERE complaints.id = numbered.id`)
+
+	// Comments written before visibility existed were all shown to
+	// everyone, so they stay public.
+	if !hadVisibility {
+		db.Exec("UPDATE comments SET visibility = ?", VisibilityPublic)
+	}
+
+	// Complaints created before watchers existed are followed by their
+	// creator and assignee, the same people who are auto-followed today.
+	if !hadWatchers {
+		db.Exec(`INSERT INTO complaint_watchers (complaint_id, user_id, created_at)
+			SELECT id, created_by_id, NOW() FROM complaints
+			UNION SELECT id, assignee_id, NOW() FROM complaints WHERE assignee_id IS NOT NULL
+			ON CONFLICT DO NOTHING`)
+	}
 }